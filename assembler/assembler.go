@@ -28,144 +28,352 @@ import (
 	"strings"
 )
 
+const programStart = 0x200
+
 type patchInfo struct {
-	inst uint16
-	lable,
-	file string
-	line int
+	inst,
+	mask uint16
+	size int
+	expr string
+	loc  Location
+	col,
+	endCol int
+}
+
+// Location identifies the source line that emitted a byte. For code
+// expanded from a macro, File and Line is the call site and MacroFile and
+// MacroLine the line of the macro definition.
+type Location struct {
+	File      string
+	Line      int
+	MacroFile string
+	MacroLine int
+}
+
+// Program describes the result of an assembly. File is the main source
+// file, Lines holds the source location of every byte in the binary,
+// Lables the address of every lable,
+// Constants the value of every equ and Sources the text of every file read.
+// Definitions and References hold where each lable and constant is defined
+// and used.
+type Program struct {
+	File        string
+	Lines       []Location
+	Lables      map[string]uint16
+	Constants   map[string]int
+	Sources     map[string][]string
+	Definitions map[string]Location
+	References  map[string][]Location
+}
+
+// Address returns the address of the first byte emitted by a line in file.
+func (prog *Program) Address(file string, line int) (uint16, bool) {
+	for offset, loc := range prog.Lines {
+		if loc.File == file && loc.Line == line {
+			return uint16(offset + programStart), true
+		}
+	}
+	return 0, false
+}
+
+// Location returns the source location of the byte at addr.
+func (prog *Program) Location(addr uint16) (Location, bool) {
+	offset := int(addr) - programStart
+	if offset < 0 || offset >= len(prog.Lines) {
+		return Location{}, false
+	}
+	return prog.Lines[offset], true
 }
 
 type assembler struct {
-	file   string
-	line   int
-	offset uint16
+	// mainFile is the first source file, and file the one being assembled.
+	mainFile string
+
+	file    string
+	line    int
+	offset  uint16
+	lines   []Location
+	kinds   []byteKind
+	sources map[string][]string
+
+	branches []branch
+	highRes  bool
+
+	// Start column of each field on the current line, nil inside macro expansions.
+	cols []int
+
+	includes []string
 
-	writer  io.WriteSeeker
-	patches map[uint16]patchInfo
-	lables  map[string]uint16
+	macro      *macro
+	macros     map[string]*macro
+	macroFile  string
+	macroLine  int
+	macroDepth int
+	expansions int
 
-	errs []error
+	macroOverflow bool
+
+	writer    io.WriteSeeker
+	writeErr  error
+	patches   map[uint16]patchInfo
+	lables    map[string]uint16
+	constants map[string]int
+	aliases   map[string]uint16
+
+	defs map[string]Location
+	refs map[string][]Location
+
+	opts  Options
+	diags []*Diagnostic
 }
 
 var Logger = log.New(os.Stdout, "", log.LstdFlags)
 
-func (asm *assembler) syntaxError() {
-	err := fmt.Errorf("syntax error, %s : %d", asm.file, asm.line)
-	asm.errs = append(asm.errs, err)
-	Logger.Println(err)
+func (asm *assembler) location() Location {
+	return Location{asm.file, asm.line, asm.macroFile, asm.macroLine}
+}
+
+// span returns the columns of field i on the current line.
+func (asm *assembler) span(args []string, i int) (int, int) {
+	if i < 0 || i >= len(args) || i >= len(asm.cols) {
+		return 0, 0
+	}
+	return asm.cols[i] + 1, asm.cols[i] + 1 + len(args[i])
+}
+
+func (asm *assembler) report(d *Diagnostic) *Diagnostic {
+	asm.diags = append(asm.diags, d)
+	return d
 }
 
-func (asm *assembler) checkLen(args []string, n int) {
+// argErrorf reports an error for field i on the current line, or the whole
+// line if i is negative.
+func (asm *assembler) argErrorf(args []string, i int, code, format string, a ...interface{}) *Diagnostic {
+	col, endCol := asm.span(args, i)
+	return asm.report(&Diagnostic{
+		Location:  asm.location(),
+		Severity:  SeverityError,
+		Column:    col,
+		EndColumn: endCol,
+		Code:      code,
+		Message:   fmt.Sprintf(format, a...),
+	})
+}
+
+func (asm *assembler) errorf(code, format string, a ...interface{}) *Diagnostic {
+	return asm.argErrorf(nil, -1, code, format, a...)
+}
+
+func (asm *assembler) checkLen(args []string, n int) bool {
 	if len(args) != n {
-		asm.syntaxError()
+		asm.argErrorf(args, 0, CodeOperandCount, "'%s' expects %d operand(s), got %d", args[0], n-1, len(args)-1)
+		return false
 	}
+	return true
 }
 
-func (asm *assembler) parseNumber(s string) (uint16, error) {
-	var (
-		n   uint64
-		err error
-	)
+func (asm *assembler) lookupSymbol(name string) (int, bool) {
+	if offset, ok := asm.lables[name]; ok {
+		return int(offset) + programStart, true
+	}
+	if n, ok := asm.constants[name]; ok {
+		return n, true
+	}
+	return 0, false
+}
 
-	if strings.HasPrefix(s, "$") {
-		n, err = strconv.ParseUint(s[1:], 16, 16)
-		if err == nil {
-			return uint16(n), nil
-		}
-	} else if strings.HasPrefix(s, "%") {
-		n, err = strconv.ParseUint(s[1:], 2, 16)
-		if err == nil {
-			return uint16(n), nil
+// eval evaluates expr and, if it succeeds, records loc as a use of every
+// symbol it references.
+func (asm *assembler) eval(expr string, loc Location) (int, error) {
+	var used []string
+	n, err := evalExpr(expr, func(name string) (int, bool) {
+		n, ok := asm.lookupSymbol(name)
+		if ok {
+			used = append(used, name)
 		}
-	} else {
-		n, err = strconv.ParseUint(s, 10, 16)
-		if err == nil {
-			return uint16(n), nil
+		return n, ok
+	})
+
+	if err == nil {
+		for _, name := range used {
+			asm.refs[name] = append(asm.refs[name], loc)
 		}
 	}
+	return n, err
+}
 
-	return 0, err
+func (asm *assembler) symbolNames() []string {
+	var names []string
+	for name := range asm.lables {
+		names = append(names, name)
+	}
+	for name := range asm.constants {
+		names = append(names, name)
+	}
+	return names
 }
 
-func (asm *assembler) parseRegName(s string) uint16 {
-	if s[0] == 'v' {
-		n, err := strconv.ParseUint(s[1:], 16, 4)
-		if err == nil {
-			return uint16(n)
+// parseOperand evaluates the expression in field i. If it references lables
+// that are not yet defined, a patch for the value at offset is queued and
+// zero is returned.
+func (asm *assembler) parseOperand(args []string, i int, offset, inst, mask uint16, size int) uint16 {
+	col, endCol := asm.span(args, i)
+	info := patchInfo{inst, mask, size, args[i], asm.location(), col, endCol}
+
+	n, err := asm.eval(args[i], info.loc)
+	if err != nil {
+		if _, ok := err.(undefinedSymbol); ok {
+			asm.patches[offset] = info
+		} else {
+			asm.argErrorf(args, i, CodeBadExpression, "%v", err)
 		}
+		return 0
+	}
+
+	if msg := checkRange(n, mask); msg != "" {
+		asm.argErrorf(args, i, CodeRange, "%s", msg)
+		return 0
+	}
+
+	asm.resolved(n, info)
+	return uint16(n) & mask
+}
+
+func isRegName(s string) bool {
+	if len(s) == 2 && s[0] == 'v' {
+		_, err := strconv.ParseUint(s[1:], 16, 4)
+		return err == nil
+	}
+	return false
+}
+
+func (asm *assembler) parseRegName(args []string, i int) uint16 {
+	s := args[i]
+	if reg, ok := asm.aliases[s]; ok {
+		return reg
+	}
+
+	if isRegName(s) {
+		n, _ := strconv.ParseUint(s[1:], 16, 4)
+		return uint16(n)
 	}
 
-	asm.syntaxError()
+	if isRegName(strings.ToLower(s)) {
+		asm.argErrorf(args, i, CodeBadRegister, "register %s must be written in lower case", s).Suggestion = strings.ToLower(s)
+	} else if len(s) == 2 && (s[0] == 'v' || s[0] == 'V') {
+		asm.argErrorf(args, i, CodeBadRegister, "register %s is not v0-vF", s)
+	} else {
+		d := asm.argErrorf(args, i, CodeBadRegister, "expected register, got '%s'", s)
+		var aliases []string
+		for name := range asm.aliases {
+			aliases = append(aliases, name)
+		}
+		d.Suggestion = suggest(s, aliases)
+	}
 	return 0
 }
 
+// writeFailed reports the first error returned by the writer. Once the
+// output is broken there is nothing more to learn from later errors.
+func (asm *assembler) writeFailed(err error) {
+	if asm.writeErr == nil {
+		asm.writeErr = err
+		asm.errorf(CodeWrite, "could not write program: %v", err)
+	}
+}
+
 func (asm *assembler) writeUint8(value byte) {
 	if err := binary.Write(asm.writer, binary.BigEndian, value); err != nil {
-		Logger.Fatalln(err)
+		asm.writeFailed(err)
 	}
 }
 
 func (asm *assembler) writeUint16(value uint16) {
 	if err := binary.Write(asm.writer, binary.BigEndian, value); err != nil {
-		Logger.Fatalln(err)
+		asm.writeFailed(err)
+	}
+}
+
+// operandCount holds the number of operands of every mnemonic, or -1 for
+// a variable number of operands.
+var operandCount = map[string]int{
+	".": -1, "..": -1,
+	"clr": 0, "rts": 0, "scrr": 0, "scrl": 0, "halt": 0, "low": 0, "high": 0,
+	"scr": 1, "jump": 1, "call": 1, "loadi": 1, "jump0": 1, "sys": 1,
+	"ske": 2, "skne": 2, "load": 2, "add": 2, "rand": 2,
+	"skre": 2, "move": 2, "or": 2, "and": 2, "xor": 2, "addr": 2, "sub": 2, "subr": 2, "sknre": 2,
+	"shr": 1, "shl": 1, "skp": 1, "sknp": 1, "moved": 1, "keyd": 1, "loadd": 1, "loads": 1,
+	"addi": 1, "ldspr": 1, "ldhspr": 1, "bcd": 1, "stor": 1, "read": 1, "storr": 1, "readr": 1,
+	"draw": 3,
+}
+
+var directives = []string{"equ", "alias", "macro", "endm", "include", "incbin"}
+
+func (asm *assembler) unknownMnemonic(args []string) {
+	candidates := append([]string{}, directives...)
+	for name := range operandCount {
+		candidates = append(candidates, name)
+	}
+	for name := range asm.macros {
+		candidates = append(candidates, name)
 	}
+
+	d := asm.argErrorf(args, 0, CodeUnknownMnemonic, "unknown mnemonic '%s'", args[0])
+	d.Suggestion = suggest(strings.ToLower(args[0]), candidates)
 }
 
 func (asm *assembler) writeOpcode(args []string) uint16 {
-	switch strings.ToLower(args[0]) {
+	mnemonic := strings.ToLower(args[0])
+	if n, ok := operandCount[mnemonic]; !ok || n >= 0 && !asm.checkLen(args, n+1) {
+		if !ok {
+			asm.unknownMnemonic(args)
+		}
+
+		// Keep the size of the program, so no more errors are caused by misplaced lables.
+		asm.writeUint16(0)
+		asm.offset += 2
+		return 2
+	}
+
+	switch mnemonic {
 	case ".":
-		n, err := asm.parseNumber(args[1])
-		if err != nil {
-			asm.syntaxError()
+		for i := range args[1:] {
+			asm.writeUint8(byte(asm.parseOperand(args, i+1, asm.offset+uint16(i), 0, 0x00FF, 1)))
 		}
 
-		asm.writeUint8(byte(n))
 		nof := uint16(len(args) - 1)
 		asm.offset += nof
 		return nof
 	case "..":
-		n, err := asm.parseNumber(args[1])
-		if err != nil {
-			asm.syntaxError()
+		for i := range args[1:] {
+			asm.writeUint16(asm.parseOperand(args, i+1, asm.offset+uint16(i)*2, 0, 0xFFFF, 2))
 		}
 
-		asm.writeUint16(n)
 		nof := uint16(len(args)-1) * 2
 		asm.offset += nof
 		return nof
 	case "scr":
-		asm.checkLen(args, 2)
-		n, err := asm.parseNumber(args[1])
-		if err != nil {
-			asm.syntaxError()
-		}
-		asm.writeUint16(0xC0 | (n & 0xF))
+		n := asm.parseOperand(args, 1, asm.offset, 0xC0, 0x000F, 2)
+		asm.writeUint16(0xC0 | n)
 	case "clr":
-		asm.checkLen(args, 1)
 		asm.writeUint16(0xE0)
 	case "rts":
-		asm.checkLen(args, 1)
 		asm.writeUint16(0xEE)
 	case "scrr":
-		asm.checkLen(args, 1)
 		asm.writeUint16(0xFB)
 	case "scrl":
-		asm.checkLen(args, 1)
 		asm.writeUint16(0xFC)
 	case "halt":
-		asm.checkLen(args, 1)
 		asm.writeUint16(0xFD)
 	case "low":
-		asm.checkLen(args, 1)
+		asm.highRes = false
 		asm.writeUint16(0xFE)
 	case "high":
-		asm.checkLen(args, 1)
+		asm.highRes = true
 		asm.writeUint16(0xFF)
 	case "jump", "call", "loadi", "jump0", "sys":
-		asm.checkLen(args, 2)
-
 		var inst uint16
-		switch args[0] {
+		switch mnemonic {
 		case "jump":
 			inst = 0x1000
 		case "call":
@@ -180,24 +388,13 @@ func (asm *assembler) writeOpcode(args []string) uint16 {
 			panic(nil)
 		}
 
-		lable := args[1]
-		n, err := asm.parseNumber(lable)
-		if err != nil {
-			asm.patches[asm.offset] = patchInfo{inst, lable, asm.file, asm.line}
-		}
-
-		asm.writeUint16(inst | (n & 0x0FFF))
+		n := asm.parseOperand(args, 1, asm.offset, inst, 0x0FFF, 2)
+		asm.writeUint16(inst | n)
 	case "ske", "skne", "load", "add", "rand":
-		asm.checkLen(args, 3)
-
-		reg := asm.parseRegName(args[1])
-		n, err := asm.parseNumber(args[2])
-		if err != nil {
-			asm.syntaxError()
-		}
+		reg := asm.parseRegName(args, 1)
 
 		var inst uint16
-		switch args[0] {
+		switch mnemonic {
 		case "ske":
 			inst = 0x3000
 		case "skne":
@@ -212,15 +409,15 @@ func (asm *assembler) writeOpcode(args []string) uint16 {
 			panic(nil)
 		}
 
-		asm.writeUint16(inst | (reg << 8) | (n & 0x00FF))
+		inst |= reg << 8
+		n := asm.parseOperand(args, 2, asm.offset, inst, 0x00FF, 2)
+		asm.writeUint16(inst | n)
 	case "skre", "move", "or", "and", "xor", "addr", "sub", "subr", "sknre":
-		asm.checkLen(args, 3)
-
-		reg0 := asm.parseRegName(args[1])
-		reg1 := asm.parseRegName(args[2])
+		reg0 := asm.parseRegName(args, 1)
+		reg1 := asm.parseRegName(args, 2)
 
 		var inst uint16
-		switch args[0] {
+		switch mnemonic {
 		case "skre":
 			inst = 0x5000
 		case "move":
@@ -244,13 +441,11 @@ func (asm *assembler) writeOpcode(args []string) uint16 {
 		}
 
 		asm.writeUint16(inst | (reg0 << 8) | (reg1 << 4))
-	case "shr", "shl", "skp", "sknp", "moved", "keyd", "loadd", "loads", "addi", "ldspr", "bcd", "stor", "read":
-		asm.checkLen(args, 2)
-
-		reg := asm.parseRegName(args[1])
+	case "shr", "shl", "skp", "sknp", "moved", "keyd", "loadd", "loads", "addi", "ldspr", "ldhspr", "bcd", "stor", "read", "storr", "readr":
+		reg := asm.parseRegName(args, 1)
 
 		var inst uint16
-		switch args[0] {
+		switch mnemonic {
 		case "shr":
 			inst = 0x8006
 		case "shl":
@@ -271,29 +466,33 @@ func (asm *assembler) writeOpcode(args []string) uint16 {
 			inst = 0xF01E
 		case "ldspr":
 			inst = 0xF029
+		case "ldhspr":
+			inst = 0xF030
 		case "bcd":
 			inst = 0xF033
 		case "stor":
 			inst = 0xF055
 		case "read":
 			inst = 0xF065
+		case "storr":
+			inst = 0xF075
+		case "readr":
+			inst = 0xF085
 		}
 
 		asm.writeUint16(inst | (reg << 8))
 	case "draw":
-		asm.checkLen(args, 4)
+		reg0 := asm.parseRegName(args, 1)
+		reg1 := asm.parseRegName(args, 2)
 
-		reg0 := asm.parseRegName(args[1])
-		reg1 := asm.parseRegName(args[2])
+		inst := 0xD000 | (reg0 << 8) | (reg1 << 4)
+		n := asm.parseOperand(args, 3, asm.offset, inst, 0x000F, 2)
+		asm.writeUint16(inst | n)
 
-		n, err := asm.parseNumber(args[3])
-		if err != nil {
-			asm.syntaxError()
+		if h, err := evalExpr(args[3], asm.lookupSymbol); err == nil && h == 0 && !asm.highRes {
+			d := asm.argErrorf(args, 3, CodeSuspicious, "draw height 0 only draws a 16x16 sprite in high resolution mode")
+			d.Severity = SeverityWarning
 		}
-
-		asm.writeUint16(0xD000 | (reg0 << 8) | (reg1 << 4) | (n & 0x000F))
-	default:
-		asm.syntaxError()
 	}
 
 	asm.offset += 2
@@ -302,72 +501,293 @@ func (asm *assembler) writeOpcode(args []string) uint16 {
 
 func (asm *assembler) patchProgram() {
 	for offset, info := range asm.patches {
-		addr, ok := asm.lables[info.lable]
-		if !ok {
-			Logger.Fatalf("unknown lable '%s', %s : %d\n", info.lable, info.file, info.line)
+		n, err := asm.eval(info.expr, info.loc)
+		if err != nil {
+			if undef, ok := err.(undefinedSymbol); ok {
+				d := asm.reportAt(info, SeverityError, CodeUndefinedSymbol, fmt.Sprintf("unknown lable '%s'", undef.name))
+				d.Suggestion = suggest(undef.name, asm.symbolNames())
+			} else {
+				asm.reportAt(info, SeverityError, CodeBadExpression, err.Error())
+			}
+			continue
 		}
 
-		asm.writer.Seek(int64(offset), 0)
-		asm.writeUint16(info.inst | ((addr + 0x200) & 0x0FFF))
-	}
-	asm.writer.Seek(0, 2)
-}
+		if msg := checkRange(n, info.mask); msg != "" {
+			asm.reportAt(info, SeverityError, CodeRange, msg)
+			continue
+		}
+		asm.resolved(n, info)
+
+		value := info.inst | (uint16(n) & info.mask)
+
+		if _, err := asm.writer.Seek(int64(offset), 0); err != nil {
+			asm.file, asm.line = info.loc.File, info.loc.Line
+			asm.writeFailed(err)
+			continue
+		}
 
-func (asm *assembler) saveLable(lable string) {
-	asm.lables[lable] = asm.offset
+		if info.size == 1 {
+			asm.writeUint8(byte(value))
+		} else {
+			asm.writeUint16(value)
+		}
+	}
+	if _, err := asm.writer.Seek(0, 2); err != nil {
+		asm.writeFailed(err)
+	}
 }
 
-func Assemble(fileName string, ifp io.Reader, ofp io.WriteSeeker) ([]int, []error) {
-	var lines []int
+// checkName reports an error if the name in field i is already in use by a
+// lable, constant, register alias or macro, or if it would shadow a register.
+func (asm *assembler) checkName(kind string, args []string, i int) bool {
+	name := strings.TrimSuffix(args[i], ":")
+	if isRegName(name) {
+		asm.argErrorf(args, i, CodeRedefinition, "%s '%s' shadows register", kind, name)
+		return false
+	}
 
-	asm := &assembler{
-		file:    fileName,
-		offset:  0,
-		lables:  make(map[string]uint16),
-		patches: make(map[uint16]patchInfo),
-		writer:  ofp,
+	if _, ok := asm.lables[name]; ok {
+		asm.argErrorf(args, i, CodeRedefinition, "%s '%s' redefines lable", kind, name)
+		return false
+	}
+	if _, ok := asm.constants[name]; ok {
+		asm.argErrorf(args, i, CodeRedefinition, "%s '%s' redefines constant", kind, name)
+		return false
 	}
+	if _, ok := asm.aliases[name]; ok {
+		asm.argErrorf(args, i, CodeRedefinition, "%s '%s' redefines alias", kind, name)
+		return false
+	}
+	if _, ok := asm.macros[name]; ok {
+		asm.argErrorf(args, i, CodeRedefinition, "%s '%s' redefines macro", kind, name)
+		return false
+	}
+	return true
+}
 
-	scanner := bufio.NewScanner(ifp)
+func (asm *assembler) saveLable(args []string) {
+	if asm.checkName("lable", args, 0) {
+		name := strings.TrimSuffix(args[0], ":")
+		asm.lables[name] = asm.offset
+		asm.defs[name] = asm.location()
+	}
+}
 
-	for asm.line = 1; scanner.Scan(); asm.line++ {
-		line := scanner.Text()
+// parseDirective handles lines that do not emit any code. It returns false
+// if args is not a directive.
+func (asm *assembler) parseDirective(args []string) bool {
+	if len(args) > 1 && strings.ToLower(args[1]) == "equ" {
+		if !asm.checkLen(args, 3) {
+			return true
+		}
 
-		for i, c := range line {
-			if c == ';' {
-				line = strings.TrimSpace(line[:i])
-				break
+		n, err := asm.eval(args[2], asm.location())
+		if err != nil {
+			if undef, ok := err.(undefinedSymbol); ok {
+				d := asm.argErrorf(args, 2, CodeUndefinedSymbol, "constant '%s' uses undefined symbol '%s'", args[0], undef.name)
+				d.Suggestion = suggest(undef.name, asm.symbolNames())
+			} else {
+				asm.argErrorf(args, 2, CodeBadExpression, "%v", err)
 			}
+			return true
 		}
 
-		args := strings.Fields(line)
-		argsLen := len(args)
+		if asm.checkName("constant", args, 0) {
+			asm.constants[args[0]] = n
+			asm.defs[args[0]] = asm.location()
+		}
+		return true
+	}
 
-		if argsLen == 0 {
-			continue
+	switch strings.ToLower(args[0]) {
+	case "include":
+		if asm.checkLen(args, 2) {
+			asm.includeSource(args)
+		}
+		return true
+	case "incbin":
+		if asm.checkLen(args, 2) {
+			asm.includeBinary(args)
+		}
+		return true
+	case "macro":
+		asm.beginMacro(args)
+		return true
+	case "endm":
+		asm.argErrorf(args, 0, CodeMacro, "endm without macro")
+		return true
+	case "alias":
+		if !asm.checkLen(args, 3) {
+			return true
 		}
 
-		first := args[0]
-		l := len(first)
+		if !isRegName(args[2]) {
+			asm.argErrorf(args, 2, CodeBadRegister, "alias '%s' does not name a register", args[1])
+			return true
+		}
 
-		if argsLen == 1 && first[l-1:] == ":" {
-			lable := first[:l-1]
-			asm.saveLable(lable)
-			continue
+		if asm.checkName("alias", args, 1) {
+			asm.aliases[args[1]] = asm.parseRegName(args, 2)
 		}
+		return true
+	}
+	return false
+}
 
-		for n := asm.writeOpcode(args); n > 0; n-- {
-			lines = append(lines, asm.line)
+func (asm *assembler) assembleLine(line string) {
+	for i, c := range line {
+		if c == ';' {
+			line = strings.TrimRight(line[:i], " \t")
+			break
 		}
 	}
 
+	args, cols := splitFields(line)
+	argsLen := len(args)
+
+	asm.cols = cols
+	if asm.macroLine > 0 {
+		asm.cols = nil
+	}
+
+	if argsLen == 0 {
+		return
+	}
+
+	if asm.macro != nil {
+		asm.recordMacroLine(args, line)
+		return
+	}
+
+	first := args[0]
+	l := len(first)
+
+	if argsLen == 1 && first[l-1:] == ":" {
+		asm.saveLable(args)
+		return
+	}
+
+	if asm.parseDirective(args) {
+		return
+	}
+
+	if m, ok := asm.macros[first]; ok {
+		asm.expandMacro(m, args)
+		return
+	}
+
+	n := asm.writeOpcode(args)
+	asm.emitted(n, first == "." || first == "..")
+}
+
+// emitted records the source location and kind of the last n bytes written.
+func (asm *assembler) emitted(n uint16, data bool) {
+	for i := uint16(0); i < n; i++ {
+		asm.lines = append(asm.lines, asm.location())
+
+		switch {
+		case data:
+			asm.kinds = append(asm.kinds, dataByte)
+		case i%2 == 0:
+			asm.kinds = append(asm.kinds, opcodeByte)
+		default:
+			asm.kinds = append(asm.kinds, operandByte)
+		}
+	}
+}
+
+func (asm *assembler) assembleSource(fileName string, ifp io.Reader) {
+	prevFile, prevLine := asm.file, asm.line
+	asm.file = fileName
+
+	scanner := bufio.NewScanner(ifp)
+	var source []string
+
+	for asm.line = 1; scanner.Scan(); asm.line++ {
+		source = append(source, scanner.Text())
+		asm.assembleLine(scanner.Text())
+	}
+	asm.sources[fileName] = source
+
 	if err := scanner.Err(); err != nil {
-		asm.syntaxError()
+		asm.errorf(CodeInclude, "%v", err)
 	}
 
+	if asm.macro != nil && asm.macro.file == fileName {
+		asm.errorf(CodeMacro, "macro '%s' without endm", asm.macro.name)
+		asm.macro = nil
+	}
+
+	asm.file, asm.line = prevFile, prevLine
+}
+
+func newAssembler(ofp io.WriteSeeker, opts Options) *assembler {
+	return &assembler{
+		opts:      opts,
+		offset:    0,
+		sources:   make(map[string][]string),
+		lables:    make(map[string]uint16),
+		constants: make(map[string]int),
+		aliases:   make(map[string]uint16),
+		defs:      make(map[string]Location),
+		refs:      make(map[string][]Location),
+		macros:    make(map[string]*macro),
+		patches:   make(map[uint16]patchInfo),
+		writer:    ofp,
+	}
+}
+
+func (asm *assembler) finish() (*Program, []*Diagnostic) {
 	asm.patchProgram()
-	size, _ := ofp.Seek(0, 2)
+	asm.checkBranches()
+
+	sortDiagnostics(asm.diags)
+	for _, locs := range asm.refs {
+		sortLocations(locs)
+	}
+	for _, d := range asm.diags {
+		if asm.opts.WarningsAsErrors {
+			d.Severity = SeverityError
+		}
+		Logger.Println(d)
+	}
+
+	size, _ := asm.writer.Seek(0, 2)
 	Logger.Printf("program size: %d bytes", size)
 
-	return lines, asm.errs
+	prog := &Program{
+		File:        asm.mainFile,
+		Lines:       asm.lines,
+		Lables:      make(map[string]uint16, len(asm.lables)),
+		Constants:   asm.constants,
+		Sources:     asm.sources,
+		Definitions: asm.defs,
+		References:  asm.refs,
+	}
+	for name, offset := range asm.lables {
+		prog.Lables[name] = offset + programStart
+	}
+	return prog, asm.diags
+}
+
+// Assemble reads source from ifp and writes the program to ofp. Files
+// referenced by include and incbin are resolved relative to fileName.
+func Assemble(fileName string, ifp io.Reader, ofp io.WriteSeeker, opts Options) (*Program, []*Diagnostic) {
+	asm := newAssembler(ofp, opts)
+	asm.mainFile = fileName
+	asm.includes = append(asm.includes, includePath(fileName))
+	asm.assembleSource(fileName, ifp)
+	return asm.finish()
+}
+
+// AssembleFiles assembles the files, in order, as a single program.
+func AssembleFiles(fileNames []string, ofp io.WriteSeeker, opts Options) (*Program, []*Diagnostic) {
+	asm := newAssembler(ofp, opts)
+	if len(fileNames) > 0 {
+		asm.mainFile = fileNames[0]
+	}
+	for _, fileName := range fileNames {
+		asm.includeFile(fileName)
+	}
+	return asm.finish()
 }
@@ -0,0 +1,301 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package assembler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+)
+
+func init() {
+	Logger = log.New(ioutil.Discard, "", 0)
+}
+
+// buffer is an in-memory io.WriteSeeker.
+type buffer struct {
+	data []byte
+	pos  int
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	if end := b.pos + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+	b.pos += copy(b.data[b.pos:], p)
+	return len(p), nil
+}
+
+func (b *buffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += int64(b.pos)
+	case io.SeekEnd:
+		offset += int64(len(b.data))
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	b.pos = int(offset)
+	return offset, nil
+}
+
+func assemble(t *testing.T, source string) ([]byte, []*Diagnostic) {
+	t.Helper()
+	var buf buffer
+	_, diags := Assemble("test.asm", strings.NewReader(source), &buf, Options{})
+	return buf.data, diags
+}
+
+func TestMnemonics(t *testing.T) {
+	tests := []struct {
+		source string
+		opcode uint16
+	}{
+		{"clr", 0x00E0},
+		{"rts", 0x00EE},
+		{"scr 5", 0x00C5},
+		{"scrr", 0x00FB},
+		{"scrl", 0x00FC},
+		{"halt", 0x00FD},
+		{"low", 0x00FE},
+		{"high", 0x00FF},
+		{"sys $102", 0x0102},
+		{"jump $234", 0x1234},
+		{"call $2F0", 0x22F0},
+		{"ske v3 $12", 0x3312},
+		{"skne v4 $FF", 0x44FF},
+		{"skre v5 v6", 0x5560},
+		{"load v7 99", 0x6763},
+		{"add v8 1", 0x7801},
+		{"move v1 v2", 0x8120},
+		{"or v1 v2", 0x8121},
+		{"and v1 v2", 0x8122},
+		{"xor v1 v2", 0x8123},
+		{"addr v1 v2", 0x8124},
+		{"sub v1 v2", 0x8125},
+		{"shr v1", 0x8106},
+		{"subr v1 v2", 0x8127},
+		{"shl v1", 0x810E},
+		{"sknre va vb", 0x9AB0},
+		{"loadi $2A6", 0xA2A6},
+		{"jump0 $300", 0xB300},
+		{"rand vc $0F", 0xCC0F},
+		{"draw vd ve 6", 0xDDE6},
+		{"skp vf", 0xEF9E},
+		{"sknp v0", 0xE0A1},
+		{"moved v1", 0xF107},
+		{"keyd v2", 0xF20A},
+		{"loadd v3", 0xF315},
+		{"loads v4", 0xF418},
+		{"addi v5", 0xF51E},
+		{"ldspr v6", 0xF629},
+		{"ldhspr v7", 0xF730},
+		{"bcd v8", 0xF833},
+		{"stor v9", 0xF955},
+		{"read va", 0xFA65},
+		{"storr vb", 0xFB75},
+		{"readr vc", 0xFC85},
+		{"LDHSPR v0", 0xF030},
+		{"StorR v7", 0xF775},
+	}
+
+	for _, test := range tests {
+		prog, diags := assemble(t, test.source)
+		if HasErrors(diags) {
+			t.Errorf("%s: %v", test.source, diags)
+			continue
+		}
+		if len(prog) != 2 {
+			t.Errorf("%s: got %d bytes, want 2", test.source, len(prog))
+			continue
+		}
+		if op := uint16(prog[0])<<8 | uint16(prog[1]); op != test.opcode {
+			t.Errorf("%s: got $%04X, want $%04X", test.source, op, test.opcode)
+		}
+	}
+}
+
+func TestRegisterOperands(t *testing.T) {
+	for _, source := range []string{"ldhspr", "storr v1 v2", "readr $10", "ldhspr vg"} {
+		_, diags := assemble(t, source)
+		if !HasErrors(diags) {
+			t.Errorf("%s: assembled without errors", source)
+		}
+	}
+}
+
+func TestData(t *testing.T) {
+	prog, diags := assemble(t, `
+Start:
+    jump    End
+    .       $01 2 %11
+    ..      $ABCD End
+End:
+`)
+	if HasErrors(diags) {
+		t.Fatal(diags)
+	}
+
+	want := []byte{0x12, 0x09, 0x01, 0x02, 0x03, 0xAB, 0xCD, 0x02, 0x09}
+	if !bytes.Equal(prog, want) {
+		t.Fatalf("got % X, want % X", prog, want)
+	}
+}
+
+// opcodes assembles source, which must have no errors.
+func opcodes(t *testing.T, source string) []byte {
+	t.Helper()
+	prog, diags := assemble(t, source)
+	if HasErrors(diags) {
+		t.Fatalf("%v\n%s", diags, source)
+	}
+	return prog
+}
+
+// expectDiagnostic checks that assembling source reports a diagnostic with
+// code on line.
+func expectDiagnostic(t *testing.T, source, code string, line int) {
+	t.Helper()
+	_, diags := assemble(t, source)
+	for _, d := range diags {
+		if d.Code == code && d.Line == line {
+			return
+		}
+	}
+	t.Errorf("no %s diagnostic on line %d in %v\n%s", code, line, diags, source)
+}
+
+func TestEqu(t *testing.T) {
+	var buf buffer
+	prog, diags := Assemble("test.asm", strings.NewReader(`
+WIDTH   equ     64
+HALF    EQU     WIDTH/2
+Start:
+    load    v0 HALF
+    loadi   (Start + HALF)
+`), &buf, Options{})
+	if HasErrors(diags) {
+		t.Fatal(diags)
+	}
+
+	if want := []byte{0x60, 0x20, 0xA2, 0x20}; !bytes.Equal(buf.data, want) {
+		t.Errorf("got % X, want % X", buf.data, want)
+	}
+	if prog.Constants["WIDTH"] != 64 || prog.Constants["HALF"] != 32 {
+		t.Errorf("constants %v", prog.Constants)
+	}
+	if loc := prog.Definitions["HALF"]; loc.Line != 3 {
+		t.Errorf("HALF defined on line %d, want 3", loc.Line)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	prog := opcodes(t, `
+    alias   x v3
+    alias   y vA
+    load    x 5
+    move    y x
+    draw    x y 1
+    shl     y
+`)
+	want := []byte{0x63, 0x05, 0x8A, 0x30, 0xD3, 0xA1, 0x8A, 0x0E}
+	if !bytes.Equal(prog, want) {
+		t.Errorf("got % X, want % X", prog, want)
+	}
+}
+
+func TestRedefinition(t *testing.T) {
+	tests := []struct {
+		source string
+		code   string
+		line   int
+	}{
+		{"A equ 1\nA equ 2", CodeRedefinition, 2},
+		{"A:\nA equ 2", CodeRedefinition, 2},
+		{"A equ 1\nA:", CodeRedefinition, 2},
+		{"alias x v1\nalias x v2", CodeRedefinition, 2},
+		{"alias x v1\nx equ 1", CodeRedefinition, 2},
+		{"x equ 1\nalias x v1", CodeRedefinition, 2},
+		{"v1 equ 3", CodeRedefinition, 1},
+		{"alias vb v1", CodeRedefinition, 1},
+		{"alias x 5", CodeBadRegister, 1},
+		{"alias x V1", CodeBadRegister, 1},
+		{"alias x", CodeOperandCount, 1},
+		{"A equ", CodeOperandCount, 1},
+		{"A equ later\nlater:", CodeUndefinedSymbol, 1},
+		{"A equ 1+", CodeBadExpression, 1},
+	}
+
+	for _, test := range tests {
+		expectDiagnostic(t, test.source, test.code, test.line)
+	}
+}
+
+func TestUnknownLables(t *testing.T) {
+	prog, diags := assemble(t, `
+    jump    Foo
+    loadi   Bar
+Known:
+    call    Known
+    .       Baz
+    ..      Qux Known
+`)
+	wantLines := []int{2, 3, 6, 7}
+	wantNames := []string{"Foo", "Bar", "Baz", "Qux"}
+	if len(diags) != len(wantNames) {
+		t.Fatalf("got %v, want %d unknown lables", diags, len(wantNames))
+	}
+	for i, d := range diags {
+		if d.Code != CodeUndefinedSymbol || d.Line != wantLines[i] || d.Message != "unknown lable '"+wantNames[i]+"'" {
+			t.Errorf("diagnostic %d: %v, want unknown lable '%s' on line %d", i, d, wantNames[i], wantLines[i])
+		}
+	}
+
+	// The lables that are known are still patched in.
+	want := []byte{0x10, 0x00, 0xA0, 0x00, 0x22, 0x04, 0x00, 0x00, 0x00, 0x02, 0x04}
+	if !bytes.Equal(prog, want) {
+		t.Errorf("got % X, want % X", prog, want)
+	}
+}
+
+// failingWriter fails every write after the first n bytes.
+type failingWriter struct {
+	buffer
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.pos+len(p) > w.n {
+		return 0, errors.New("disk full")
+	}
+	return w.buffer.Write(p)
+}
+
+func TestWriteError(t *testing.T) {
+	w := &failingWriter{n: 2}
+	_, diags := Assemble("test.asm", strings.NewReader("clr\nclr\nclr\njump End\nEnd:"), w, Options{})
+	if len(diags) != 1 || diags[0].Code != CodeWrite || diags[0].Line != 2 {
+		t.Errorf("got %v, want one write error on line 2", diags)
+	}
+}
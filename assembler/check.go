@@ -0,0 +1,101 @@
+/*
+Copyright (C) 2016-2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package assembler
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Options change how a program is assembled.
+type Options struct {
+	// WarningsAsErrors turns every warning into an error.
+	WarningsAsErrors bool
+}
+
+type byteKind byte
+
+const (
+	opcodeByte byteKind = iota
+	operandByte
+	dataByte
+)
+
+type branch struct {
+	target uint16
+	pos    patchInfo
+}
+
+// checkRange returns an error message if n does not fit in the field
+// described by mask. Bytes and words also accept signed numbers, down to
+// -128 and -32768, which are stored in two's complement.
+func checkRange(n int, mask uint16) string {
+	size := bits.OnesCount16(mask)
+	min, max := 0, int(mask)
+	if size == 8 || size == 16 {
+		min = -(int(mask) + 1) / 2
+	}
+
+	if n >= min && n <= max {
+		return ""
+	}
+	if size == 12 && n >= 0 {
+		return fmt.Sprintf("address $%X does not fit in 12 bits", n)
+	}
+	if size == 12 {
+		return fmt.Sprintf("address %d does not fit in 12 bits", n)
+	}
+	return fmt.Sprintf("immediate %d does not fit in %d bits", n, size)
+}
+
+// resolved is called when the value of an operand is known, to remember
+// jump and call targets for checkBranches.
+func (asm *assembler) resolved(n int, info patchInfo) {
+	if info.mask == 0x0FFF && (info.inst&0xF000 == 0x1000 || info.inst&0xF000 == 0x2000) {
+		asm.branches = append(asm.branches, branch{uint16(n), info})
+	}
+}
+
+// checkBranches warns about jumps and calls into data or into the middle
+// of an instruction.
+func (asm *assembler) checkBranches() {
+	for _, b := range asm.branches {
+		offset := int(b.target) - programStart
+		if offset < 0 || offset >= len(asm.kinds) {
+			continue
+		}
+
+		switch asm.kinds[offset] {
+		case dataByte:
+			asm.reportAt(b.pos, SeverityWarning, CodeSuspicious, fmt.Sprintf("branch target $%03X is data", b.target))
+		case operandByte:
+			asm.reportAt(b.pos, SeverityWarning, CodeSuspicious, fmt.Sprintf("branch target $%03X is inside an instruction", b.target))
+		}
+	}
+}
+
+func (asm *assembler) reportAt(pos patchInfo, severity Severity, code, msg string) *Diagnostic {
+	return asm.report(&Diagnostic{
+		Location:  pos.loc,
+		Severity:  severity,
+		Column:    pos.col,
+		EndColumn: pos.endCol,
+		Code:      code,
+		Message:   msg,
+	})
+}
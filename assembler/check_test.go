@@ -0,0 +1,128 @@
+/*
+Copyright (C) 2016-2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package assembler
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCheckRange(t *testing.T) {
+	tests := []struct {
+		n    int
+		mask uint16
+		msg  string
+	}{
+		{0, 0x000F, ""},
+		{15, 0x000F, ""},
+		{16, 0x000F, "immediate 16 does not fit in 4 bits"},
+		{-1, 0x000F, "immediate -1 does not fit in 4 bits"},
+		{255, 0x00FF, ""},
+		{-128, 0x00FF, ""},
+		{256, 0x00FF, "immediate 256 does not fit in 8 bits"},
+		{-129, 0x00FF, "immediate -129 does not fit in 8 bits"},
+		{-300, 0x00FF, "immediate -300 does not fit in 8 bits"},
+		{0xFFF, 0x0FFF, ""},
+		{0x1000, 0x0FFF, "address $1000 does not fit in 12 bits"},
+		{-1, 0x0FFF, "address -1 does not fit in 12 bits"},
+		{65535, 0xFFFF, ""},
+		{-32768, 0xFFFF, ""},
+		{65536, 0xFFFF, "immediate 65536 does not fit in 16 bits"},
+		{-32769, 0xFFFF, "immediate -32769 does not fit in 16 bits"},
+	}
+
+	for _, test := range tests {
+		if msg := checkRange(test.n, test.mask); msg != test.msg {
+			t.Errorf("checkRange(%d, $%04X) = %q, want %q", test.n, test.mask, msg, test.msg)
+		}
+	}
+}
+
+func TestRangeErrors(t *testing.T) {
+	for _, source := range []string{
+		"load v0 -300",
+		"load v0 -129",
+		"add v0 256",
+		". 256",
+		"..  -32769",
+		"scr 16",
+		"draw v0 v1 -1",
+		"jump $1000",
+		"jump -2",
+		"Start:\nloadi (Start + $E00)",
+		"jump Later\nLater equ $1000",
+	} {
+		if _, diags := assemble(t, source); len(diags) != 1 || diags[0].Code != CodeRange {
+			t.Errorf("%q: got %v, want one range error", source, diags)
+		}
+	}
+
+	// Signed bytes and words are stored in two's complement.
+	prog := opcodes(t, "load v0 -128\nadd v1 -2\n. -1\n.. -2")
+	if want := []byte{0x60, 0x80, 0x71, 0xFE, 0xFF, 0xFF, 0xFE}; !bytes.Equal(prog, want) {
+		t.Errorf("got % X, want % X", prog, want)
+	}
+}
+
+func TestBranchWarnings(t *testing.T) {
+	tests := []struct {
+		source string
+		line   int
+		msg    string
+	}{
+		{"jump Data\nData:\n. 1 2", 1, "branch target $202 is data"},
+		{"call Data\nclr\nData:\n.. $1234", 1, "branch target $204 is data"},
+		{"clr\njump $201", 2, "branch target $201 is inside an instruction"},
+		{"jump (Later + 1)\nLater:\nclr", 1, "branch target $203 is inside an instruction"},
+	}
+
+	for _, test := range tests {
+		_, diags := assemble(t, test.source)
+		if len(diags) != 1 || diags[0].Severity != SeverityWarning || diags[0].Code != CodeSuspicious ||
+			diags[0].Line != test.line || diags[0].Message != test.msg {
+			t.Errorf("%q: got %v, want warning %q on line %d", test.source, diags, test.msg, test.line)
+		}
+	}
+
+	// Branches to code, past the program, or with loadi and jump0 are fine.
+	for _, source := range []string{
+		"Loop:\njump Loop",
+		"call Sub\nSub:\nrts",
+		"jump $300",
+		"loadi Data\njump0 Data\nData:\n. 1",
+		"jump $100",
+	} {
+		if _, diags := assemble(t, source); len(diags) != 0 {
+			t.Errorf("%q: got %v, want no diagnostics", source, diags)
+		}
+	}
+}
+
+func TestWarningsAsErrors(t *testing.T) {
+	source := "jump Data\nData:\n. 1"
+	var buf buffer
+	_, diags := Assemble("test.asm", bytes.NewReader([]byte(source)), &buf, Options{WarningsAsErrors: true})
+	if len(diags) != 1 || diags[0].Severity != SeverityError {
+		t.Errorf("got %v, want the warning as an error", diags)
+	}
+
+	// The option only applies to the assembly it is passed to.
+	if _, diags := assemble(t, source); len(diags) != 1 || diags[0].Severity != SeverityWarning {
+		t.Errorf("got %v, want a warning", diags)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright (C) 2016-2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package assembler
+
+import (
+	"fmt"
+	"sort"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Diagnostic codes, to let tools tell different kinds of problems apart.
+const (
+	CodeUnknownMnemonic = "unknown-mnemonic"
+	CodeOperandCount    = "operand-count"
+	CodeBadRegister     = "bad-register"
+	CodeBadExpression   = "bad-expression"
+	CodeUndefinedSymbol = "undefined-symbol"
+	CodeRedefinition    = "redefinition"
+	CodeMacro           = "macro"
+	CodeInclude         = "include"
+	CodeWrite           = "write"
+	CodeRange           = "range"
+	CodeSuspicious      = "suspicious"
+)
+
+// Diagnostic describes a problem in the source. Column and EndColumn
+// are the 1-based byte span of the offending text on the line, or zero
+// if unknown. Suggestion is a likely replacement for the offending text.
+type Diagnostic struct {
+	Location
+	Severity   Severity
+	Column     int
+	EndColumn  int
+	Code       string
+	Message    string
+	Suggestion string
+}
+
+func (d *Diagnostic) Error() string {
+	pos := fmt.Sprintf("%s:%d", d.File, d.Line)
+	if d.Column > 0 {
+		pos = fmt.Sprintf("%s:%d", pos, d.Column)
+	}
+
+	msg := fmt.Sprintf("%s: %s: %s", pos, d.Severity, d.Message)
+	if d.Suggestion != "" {
+		msg = fmt.Sprintf("%s, did you mean '%s'?", msg, d.Suggestion)
+	}
+	if d.MacroLine > 0 {
+		msg = fmt.Sprintf("%s (in macro at %s:%d)", msg, d.MacroFile, d.MacroLine)
+	}
+	return msg
+}
+
+// CountErrors returns the number of diagnostics that are errors.
+func CountErrors(diags []*Diagnostic) int {
+	n := 0
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			n++
+		}
+	}
+	return n
+}
+
+// HasErrors returns true if any of the diagnostics is an error.
+func HasErrors(diags []*Diagnostic) bool {
+	return CountErrors(diags) > 0
+}
+
+func sortDiagnostics(diags []*Diagnostic) {
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i], diags[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
+
+// suggest returns the candidate closest to s, if it is close enough to
+// likely be a misspelling.
+func suggest(s string, candidates []string) string {
+	best, bestDist := "", len(s)/2+1
+	for _, c := range candidates {
+		if d := editDistance(s, c); d < bestDist || d == bestDist && best != "" && c < best {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the number of insertions, deletions, substitutions
+// and transpositions needed to turn a into b.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,139 @@
+/*
+Copyright (C) 2016-2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package assembler
+
+import "testing"
+
+func TestDiagnosticError(t *testing.T) {
+	tests := []struct {
+		d    Diagnostic
+		want string
+	}{
+		{
+			Diagnostic{Location: Location{File: "a.asm", Line: 3}, Message: "oops"},
+			"a.asm:3: error: oops",
+		},
+		{
+			Diagnostic{Location: Location{File: "a.asm", Line: 3}, Severity: SeverityWarning, Column: 7, EndColumn: 9, Message: "hmm"},
+			"a.asm:3:7: warning: hmm",
+		},
+		{
+			Diagnostic{Location: Location{File: "a.asm", Line: 3}, Column: 5, Message: "unknown mnemonic 'lod'", Suggestion: "load"},
+			"a.asm:3:5: error: unknown mnemonic 'lod', did you mean 'load'?",
+		},
+		{
+			Diagnostic{Location: Location{"a.asm", 10, "m.asm", 2}, Message: "oops", Suggestion: "x"},
+			"a.asm:10: error: oops, did you mean 'x'? (in macro at m.asm:2)",
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.d.Error(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		source     string
+		code       string
+		col        int
+		endCol     int
+		suggestion string
+	}{
+		{"    lod v0 1", CodeUnknownMnemonic, 5, 8, "load"},
+		{"    laod v0 1", CodeUnknownMnemonic, 5, 9, "load"},
+		{"    xyzzy", CodeUnknownMnemonic, 5, 10, ""},
+		{"    load V1 1", CodeBadRegister, 10, 12, "v1"},
+		{"    load vg 1", CodeBadRegister, 10, 12, ""},
+		{"    alias pos v1\n    load pso 1", CodeBadRegister, 10, 13, "pos"},
+		{"Loop:\n    jump Lopo", CodeUndefinedSymbol, 10, 14, "Loop"},
+		{"    load v0 1 2", CodeOperandCount, 5, 9, ""},
+		{"    load v0 (1+)", CodeBadExpression, 13, 17, ""},
+		{"    load v0 300", CodeRange, 13, 16, ""},
+		{"    draw v0 v1 0", CodeSuspicious, 16, 17, ""},
+	}
+
+	for _, test := range tests {
+		_, diags := assemble(t, test.source)
+		if len(diags) != 1 {
+			t.Errorf("%q: got %v, want one diagnostic", test.source, diags)
+			continue
+		}
+
+		d := diags[0]
+		if d.Code != test.code || d.Column != test.col || d.EndColumn != test.endCol || d.Suggestion != test.suggestion {
+			t.Errorf("%q: got %s %d-%d %q, want %s %d-%d %q", test.source, d.Code, d.Column, d.EndColumn, d.Suggestion,
+				test.code, test.col, test.endCol, test.suggestion)
+		}
+	}
+}
+
+func TestDiagnosticOrder(t *testing.T) {
+	// Errors found when patching lables are sorted in with the others.
+	_, diags := assemble(t, "    jump a\n    lod\n    jump b\n    jump c")
+	if len(diags) != 4 {
+		t.Fatalf("got %v, want 4 diagnostics", diags)
+	}
+	for i, d := range diags {
+		if d.Line != i+1 {
+			t.Errorf("diagnostic %d is on line %d: %v", i, d.Line, diags)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"load", "load", 0},
+		{"", "abc", 3},
+		{"lod", "load", 1},
+		{"laod", "load", 1},
+		{"loda", "load", 1},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, test := range tests {
+		if d := editDistance(test.a, test.b); d != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.a, test.b, d, test.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	candidates := []string{"load", "loadi", "loads", "read"}
+	tests := []struct {
+		s, want string
+	}{
+		{"lod", "load"},
+		{"loadx", "load"},
+		{"rad", "read"},
+		{"xyz", ""},
+		{"l", ""},
+	}
+
+	for _, test := range tests {
+		if got := suggest(test.s, candidates); got != test.want {
+			t.Errorf("suggest(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+/*
+Copyright (C) 2016-2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package assembler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// undefinedSymbol is returned by evalExpr when the expression references
+// a symbol that is not (yet) known. The caller may retry later, for
+// example when a forward referenced lable has been defined.
+type undefinedSymbol struct {
+	name string
+}
+
+func (err undefinedSymbol) Error() string {
+	return fmt.Sprintf("undefined symbol '%s'", err.name)
+}
+
+var errDivisionByZero = errors.New("division by zero")
+
+type exprParser struct {
+	src    string
+	pos    int
+	lookup func(string) (int, bool)
+	undef  error
+}
+
+// evalExpr evaluates a constant expression. Supported are decimal, $hex and
+// %binary literals, symbols, parentheses, the unary operators - + ~ and the
+// binary operators * / % + - << >> & ^ |, with C precedence. The functions
+// lo(x) and hi(x) return the low and high byte of x.
+func evalExpr(s string, lookup func(string) (int, bool)) (int, error) {
+	p := &exprParser{src: s, lookup: lookup}
+	n, err := p.parseBinary(0)
+	if err != nil {
+		return 0, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return 0, fmt.Errorf("unexpected '%s' in expression", p.src[p.pos:])
+	}
+	if p.undef != nil {
+		return 0, p.undef
+	}
+	return n, nil
+}
+
+var binaryOperators = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) accept(ops []string) string {
+	p.skipSpace()
+	for _, op := range ops {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *exprParser) parseBinary(level int) (int, error) {
+	if level == len(binaryOperators) {
+		return p.parseUnary()
+	}
+
+	lhs, err := p.parseBinary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := p.accept(binaryOperators[level])
+		if op == "" {
+			return lhs, nil
+		}
+
+		rhs, err := p.parseBinary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case "|":
+			lhs |= rhs
+		case "^":
+			lhs ^= rhs
+		case "&":
+			lhs &= rhs
+		case "<<":
+			lhs <<= uint(rhs & 0x1F)
+		case ">>":
+			lhs >>= uint(rhs & 0x1F)
+		case "+":
+			lhs += rhs
+		case "-":
+			lhs -= rhs
+		case "*":
+			lhs *= rhs
+		case "/", "%":
+			if rhs == 0 {
+				// Unresolved symbols evaluate to zero, so only fail once everything is known.
+				if p.undef != nil {
+					continue
+				}
+				return 0, errDivisionByZero
+			}
+			if op == "/" {
+				lhs /= rhs
+			} else {
+				lhs %= rhs
+			}
+		default:
+			panic(nil)
+		}
+	}
+}
+
+func (p *exprParser) parseUnary() (int, error) {
+	switch p.accept([]string{"-", "+", "~"}) {
+	case "-":
+		n, err := p.parseUnary()
+		return -n, err
+	case "+":
+		return p.parseUnary()
+	case "~":
+		n, err := p.parseUnary()
+		return ^n, err
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (int, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0, errors.New("unexpected end of expression")
+	}
+
+	if p.accept([]string{"("}) != "" {
+		n, err := p.parseBinary(0)
+		if err != nil {
+			return 0, err
+		}
+		if p.accept([]string{")"}) == "" {
+			return 0, errors.New("missing ')' in expression")
+		}
+		return n, nil
+	}
+
+	start := p.pos
+	switch c := p.src[p.pos]; {
+	case c == '$':
+		p.pos++
+		return p.parseLiteral(start, 1, 16)
+	case c == '%':
+		p.pos++
+		return p.parseLiteral(start, 1, 2)
+	case c >= '0' && c <= '9':
+		return p.parseLiteral(start, 0, 10)
+	case isSymbolChar(c, true):
+		for p.pos < len(p.src) && isSymbolChar(p.src[p.pos], false) {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+
+		if fn, ok := exprFunctions[strings.ToLower(name)]; ok && p.accept([]string{"("}) != "" {
+			n, err := p.parseBinary(0)
+			if err != nil {
+				return 0, err
+			}
+			if p.accept([]string{")"}) == "" {
+				return 0, errors.New("missing ')' in expression")
+			}
+			return fn(n), nil
+		}
+
+		if n, ok := p.lookup(name); ok {
+			return n, nil
+		}
+		if p.undef == nil {
+			p.undef = undefinedSymbol{name}
+		}
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unexpected '%c' in expression", c)
+	}
+}
+
+func (p *exprParser) parseLiteral(start, prefix, base int) (int, error) {
+	for p.pos < len(p.src) && isSymbolChar(p.src[p.pos], false) {
+		p.pos++
+	}
+
+	n, err := strconv.ParseUint(p.src[start+prefix:p.pos], base, 16)
+	if err != nil {
+		if err.(*strconv.NumError).Err == strconv.ErrRange {
+			return 0, fmt.Errorf("immediate %s does not fit in 16 bits", p.src[start:p.pos])
+		}
+		return 0, fmt.Errorf("invalid number '%s'", p.src[start:p.pos])
+	}
+	return int(n), nil
+}
+
+var exprFunctions = map[string]func(int) int{
+	"lo": func(n int) int { return n & 0xFF },
+	"hi": func(n int) int { return (n >> 8) & 0xFF },
+}
+
+func isSymbolChar(c byte, first bool) bool {
+	switch {
+	case c == '_', c == '.', c == '@', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
+
+// splitFields splits a source line on white space, except inside
+// parentheses and double quotes, so expressions with spaces can be
+// written as "(a + b)". The offset of every field is returned as well.
+func splitFields(line string) ([]string, []int) {
+	var (
+		fields  []string
+		offsets []int
+		depth   int
+		quoted  bool
+		start   = -1
+	)
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth > 0 {
+				depth--
+			}
+		case (c == ' ' || c == '\t') && depth == 0:
+			if start >= 0 {
+				fields = append(fields, line[start:i])
+				offsets = append(offsets, start)
+				start = -1
+			}
+			continue
+		}
+
+		if start < 0 {
+			start = i
+		}
+	}
+
+	if start >= 0 {
+		fields = append(fields, line[start:])
+		offsets = append(offsets, start)
+	}
+	return fields, offsets
+}
@@ -0,0 +1,153 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package assembler
+
+import "testing"
+
+var testSymbols = map[string]int{
+	"WIDTH":  64,
+	"HEIGHT": 32,
+	"sprite": 0x2A6,
+	".local": 3,
+}
+
+func lookupTestSymbol(name string) (int, bool) {
+	n, ok := testSymbols[name]
+	return n, ok
+}
+
+func TestEvalExpr(t *testing.T) {
+	tests := []struct {
+		expr string
+		want int
+	}{
+		{"42", 42},
+		{"$2A", 0x2A},
+		{"$ff", 0xFF},
+		{"%1010", 10},
+		{"WIDTH", 64},
+		{".local", 3},
+		{"-1", -1},
+		{"+5", 5},
+		{"~0 & $FF", 0xFF},
+		{"- -3", 3},
+
+		// Precedence, from tightest to loosest: * / %, + -, << >>, &, ^, |.
+		{"2 + 3 * 4", 14},
+		{"2 * 3 + 4", 10},
+		{"10 - 4 / 2", 8},
+		{"7 % 3 + 1", 2},
+		{"1 + 1 << 2", 8},
+		{"1 << 2 + 1", 8},
+		{"$F0 | $0F & $3C", 0xFC},
+		{"$FF ^ $0F & $F0", 0xFF},
+		{"$F0 | $3C ^ $0F", 0xF3},
+		{"1 | 2 ^ 3 & 4 << 1 + 2 * 3", 1 | (2 ^ (3 & (4 << (1 + 2*3))))},
+		{"-2 * 3", -6},
+		{"~1 + 2", 0},
+
+		// Operators of the same level are left associative.
+		{"10 - 3 - 2", 5},
+		{"100 / 10 / 5", 2},
+		{"64 >> 2 >> 1", 8},
+		{"17 % 10 % 4", 3},
+
+		{"(2 + 3) * 4", 20},
+		{"((1))", 1},
+		{"(WIDTH - 8) / 2", 28},
+		{"WIDTH * HEIGHT / 8", 256},
+		{"lo(sprite)", 0xA6},
+		{"hi(sprite)", 0x02},
+		{"HI(sprite + $100)", 0x03},
+		{"lo(WIDTH) + hi($1234) * 2", 64 + 0x24},
+		{" 1+2 ", 3},
+	}
+
+	for _, test := range tests {
+		got, err := evalExpr(test.expr, lookupTestSymbol)
+		if err != nil {
+			t.Errorf("evalExpr(%q): %v", test.expr, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("evalExpr(%q) = %d, want %d", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestEvalExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"(1 + 2", "missing ')' in expression"},
+		{"lo(1", "missing ')' in expression"},
+		{"1 + 2)", "unexpected ')' in expression"},
+		{"1 2", "unexpected '2' in expression"},
+		{"#3", "unexpected '#' in expression"},
+		{"$10000", "immediate $10000 does not fit in 16 bits"},
+		{"$xyz", "invalid number '$xyz'"},
+		{"12ab", "invalid number '12ab'"},
+		{"1 / 0", "division by zero"},
+		{"1 % (2 - 2)", "division by zero"},
+		{"missing + 1", "undefined symbol 'missing'"},
+	}
+
+	for _, test := range tests {
+		_, err := evalExpr(test.expr, lookupTestSymbol)
+		if err == nil {
+			t.Errorf("evalExpr(%q) succeeded, want error %q", test.expr, test.err)
+			continue
+		}
+		if err.Error() != test.err {
+			t.Errorf("evalExpr(%q) failed with %q, want %q", test.expr, err, test.err)
+		}
+	}
+}
+
+func TestEvalExprUndefined(t *testing.T) {
+	// Division by an unresolved symbol is not an error, as the symbol may
+	// be a forward referenced lable.
+	_, err := evalExpr("WIDTH / later", lookupTestSymbol)
+	if _, ok := err.(undefinedSymbol); !ok {
+		t.Fatalf("got %v, want undefinedSymbol", err)
+	}
+
+	// The first undefined symbol is reported.
+	_, err = evalExpr("first + second", lookupTestSymbol)
+	if err != (undefinedSymbol{"first"}) {
+		t.Fatalf("got %v, want undefined symbol 'first'", err)
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	fields, offsets := splitFields(`ld v0, (WIDTH - 8) / 2 "a b"`)
+	want := []string{"ld", "v0,", "(WIDTH - 8)", "/", "2", `"a b"`}
+	wantOffsets := []int{0, 3, 7, 19, 21, 23}
+
+	if len(fields) != len(want) {
+		t.Fatalf("got %q, want %q", fields, want)
+	}
+	for i := range want {
+		if fields[i] != want[i] || offsets[i] != wantOffsets[i] {
+			t.Errorf("field %d is %q at %d, want %q at %d", i, fields[i], offsets[i], want[i], wantOffsets[i])
+		}
+	}
+}
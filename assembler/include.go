@@ -0,0 +1,106 @@
+/*
+Copyright (C) 2016-2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package assembler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func includePath(fileName string) string {
+	if path, err := filepath.Abs(fileName); err == nil {
+		return path
+	}
+	return filepath.Clean(fileName)
+}
+
+// resolveInclude returns the path of a quoted file name relative to the
+// file currently being assembled.
+func (asm *assembler) resolveInclude(args []string) (string, bool) {
+	arg := args[1]
+	if len(arg) < 2 || arg[0] != '"' || arg[len(arg)-1] != '"' {
+		asm.argErrorf(args, 1, CodeInclude, "expected quoted file name, got %s", arg)
+		return "", false
+	}
+
+	name := filepath.FromSlash(arg[1 : len(arg)-1])
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(asm.file), name)
+	}
+	return name, true
+}
+
+func (asm *assembler) includeSource(args []string) {
+	if fileName, ok := asm.resolveInclude(args); ok {
+		asm.includeFile(fileName)
+	}
+}
+
+func (asm *assembler) includeFile(fileName string) {
+	path := includePath(fileName)
+	for i, p := range asm.includes {
+		if p == path {
+			cycle := append(append([]string{}, asm.includes[i:]...), path)
+			for j, p := range cycle {
+				cycle[j] = filepath.Base(p)
+			}
+			asm.errorf(CodeInclude, "include cycle %s", strings.Join(cycle, " -> "))
+			return
+		}
+	}
+
+	fp, err := os.Open(fileName)
+	if err != nil {
+		asm.errorf(CodeInclude, "%v", err)
+		return
+	}
+	defer fp.Close()
+
+	// Included code is not part of any macro expansion.
+	prevMacroFile, prevMacroLine := asm.macroFile, asm.macroLine
+	asm.macroFile, asm.macroLine = "", 0
+
+	asm.includes = append(asm.includes, path)
+	asm.assembleSource(fileName, fp)
+	asm.includes = asm.includes[:len(asm.includes)-1]
+
+	asm.macroFile, asm.macroLine = prevMacroFile, prevMacroLine
+}
+
+func (asm *assembler) includeBinary(args []string) {
+	fileName, ok := asm.resolveInclude(args)
+	if !ok {
+		return
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		asm.argErrorf(args, 1, CodeInclude, "%v", err)
+		return
+	}
+
+	for _, b := range data {
+		asm.writeUint8(b)
+	}
+
+	n := uint16(len(data))
+	asm.offset += n
+	asm.emitted(n, true)
+}
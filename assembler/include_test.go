@@ -0,0 +1,127 @@
+/*
+Copyright (C) 2016-2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package assembler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files, named by slash separated paths, to a new
+// temporary directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "assembler")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func assembleFile(t *testing.T, fileName string) ([]byte, *Program, []*Diagnostic) {
+	t.Helper()
+	var buf buffer
+	prog, diags := AssembleFiles([]string{fileName}, &buf, Options{})
+	return buf.data, prog, diags
+}
+
+func TestInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.asm":      "    include \"lib/a.asm\"\n    clr\n    incbin \"lib/data.bin\"\n",
+		"lib/a.asm":     "; a\n    include \"sub/b.asm\"\n    rts\n",
+		"lib/sub/b.asm": "Sprite:\n    loadi Sprite\n",
+		"lib/data.bin":  "\x01\x02\x03",
+	})
+	defer os.RemoveAll(dir)
+
+	mainFile := filepath.Join(dir, "main.asm")
+	data, prog, diags := assembleFile(t, mainFile)
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+
+	want := []byte{0xA2, 0x00, 0x00, 0xEE, 0x00, 0xE0, 0x01, 0x02, 0x03}
+	if !bytes.Equal(data, want) {
+		t.Errorf("got % X, want % X", data, want)
+	}
+
+	// Every byte is located in the file it came from.
+	a, b := filepath.Join(dir, "lib", "a.asm"), filepath.Join(dir, "lib", "sub", "b.asm")
+	wantLines := []Location{
+		{File: b, Line: 2}, {File: b, Line: 2},
+		{File: a, Line: 3}, {File: a, Line: 3},
+		{File: mainFile, Line: 2}, {File: mainFile, Line: 2},
+		{File: mainFile, Line: 3}, {File: mainFile, Line: 3}, {File: mainFile, Line: 3},
+	}
+	for i, loc := range wantLines {
+		if i >= len(prog.Lines) || prog.Lines[i] != loc {
+			t.Fatalf("byte %d at %+v, want %+v", i, prog.Lines, wantLines)
+		}
+	}
+	if addr, ok := prog.Address(a, 3); !ok || addr != 0x202 {
+		t.Errorf("%s:3 at $%03X, want $202", a, addr)
+	}
+	if len(prog.Sources) != 3 || prog.Sources[b][0] != "Sprite:" {
+		t.Errorf("sources of %d files", len(prog.Sources))
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.asm":       "    clr\n    include \"b.asm\"\n",
+		"b.asm":       "    include \"a.asm\"\n",
+		"self.asm":    "    include \"self.asm\"\n",
+		"missing.asm": "    include \"none.asm\"\n    incbin \"none.bin\"\n",
+		"quotes.asm":  "    include b.asm\n    incbin\n",
+	})
+	defer os.RemoveAll(dir)
+
+	_, _, diags := assembleFile(t, filepath.Join(dir, "a.asm"))
+	if len(diags) != 1 || diags[0].Message != "include cycle a.asm -> b.asm -> a.asm" || diags[0].File != filepath.Join(dir, "b.asm") {
+		t.Errorf("a.asm: got %v, want a cycle in b.asm", diags)
+	}
+
+	_, _, diags = assembleFile(t, filepath.Join(dir, "self.asm"))
+	if len(diags) != 1 || diags[0].Message != "include cycle self.asm -> self.asm" {
+		t.Errorf("self.asm: got %v, want a cycle", diags)
+	}
+
+	_, _, diags = assembleFile(t, filepath.Join(dir, "missing.asm"))
+	if len(diags) != 2 || diags[0].Code != CodeInclude || diags[1].Code != CodeInclude || !strings.Contains(diags[1].Message, "none.bin") {
+		t.Errorf("missing.asm: got %v, want two include errors", diags)
+	}
+
+	_, _, diags = assembleFile(t, filepath.Join(dir, "quotes.asm"))
+	if len(diags) != 2 || diags[0].Code != CodeInclude || diags[1].Code != CodeOperandCount {
+		t.Errorf("quotes.asm: got %v", diags)
+	}
+}
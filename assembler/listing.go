@@ -0,0 +1,178 @@
+/*
+Copyright (C) 2016-2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package assembler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const listingBytes = 4
+
+type symbol struct {
+	name string
+	addr uint16
+}
+
+func (prog *Program) sortedLables() []symbol {
+	var symbols []symbol
+	for name, addr := range prog.Lables {
+		symbols = append(symbols, symbol{name, addr})
+	}
+
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].addr == symbols[j].addr {
+			return symbols[i].name < symbols[j].name
+		}
+		return symbols[i].addr < symbols[j].addr
+	})
+	return symbols
+}
+
+func sortLocations(locs []Location) {
+	sort.SliceStable(locs, func(i, j int) bool {
+		if locs[i].File != locs[j].File {
+			return locs[i].File < locs[j].File
+		}
+		return locs[i].Line < locs[j].Line
+	})
+}
+
+// formatLocation formats loc with the file relative to the main source
+// file, so included files with the same name in different directories can
+// be told apart.
+func (prog *Program) formatLocation(loc Location) string {
+	file := loc.File
+	if rel, err := filepath.Rel(filepath.Dir(prog.File), file); err == nil {
+		file = filepath.ToSlash(rel)
+	}
+	return fmt.Sprintf("%s:%d", file, loc.Line)
+}
+
+// symbolNames returns the name of every lable and constant, sorted.
+func (prog *Program) symbolNames() []string {
+	var names []string
+	for name := range prog.Lables {
+		names = append(names, name)
+	}
+	for name := range prog.Constants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (prog *Program) sourceLine(file string, line int) string {
+	if source := prog.Sources[file]; line > 0 && line <= len(source) {
+		return strings.TrimRight(source[line-1], " \t")
+	}
+	return ""
+}
+
+// WriteListing writes the address, bytes, source location and source
+// text of every line in the assembled binary to w, followed by a symbol
+// table and a cross reference of where every symbol is used.
+func (prog *Program) WriteListing(w io.Writer, binary []byte) error {
+	bw := bufio.NewWriter(w)
+	lables := prog.sortedLables()
+
+	for offset := 0; offset < len(prog.Lines) && offset < len(binary); {
+		loc := prog.Lines[offset]
+		addr := uint16(offset + programStart)
+
+		for len(lables) > 0 && lables[0].addr <= addr {
+			fmt.Fprintf(bw, "%33s%s:\n", "", lables[0].name)
+			lables = lables[1:]
+		}
+
+		end := offset + 1
+		for end < len(prog.Lines) && end < len(binary) && end-offset < listingBytes && prog.Lines[end] == loc {
+			end++
+		}
+
+		var hex []string
+		for _, b := range binary[offset:end] {
+			hex = append(hex, fmt.Sprintf("%02X", b))
+		}
+
+		text := prog.sourceLine(loc.File, loc.Line)
+		if offset > 0 && prog.Lines[offset-1] == loc {
+			text = ""
+		} else if loc.MacroLine > 0 {
+			text = fmt.Sprintf("%-24s; %s", text, strings.TrimSpace(prog.sourceLine(loc.MacroFile, loc.MacroLine)))
+		}
+
+		line := fmt.Sprintf("%04X  %-12s %-14s%s", addr, strings.Join(hex, " "), prog.formatLocation(loc), text)
+		fmt.Fprintln(bw, strings.TrimRight(line, " "))
+		offset = end
+	}
+
+	for _, s := range lables {
+		fmt.Fprintf(bw, "%33s%s:\n", "", s.name)
+	}
+
+	prog.writeSymbolTable(bw)
+	prog.writeCrossReference(bw)
+	return bw.Flush()
+}
+
+// writeSymbolTable writes the value, kind and definition of every lable
+// and constant.
+func (prog *Program) writeSymbolTable(w io.Writer) {
+	fmt.Fprintf(w, "\nSymbols:\n\n")
+	for _, name := range prog.symbolNames() {
+		kind, value := "lable", ""
+		if addr, ok := prog.Lables[name]; ok {
+			value = fmt.Sprintf("$%03X", addr)
+		} else {
+			kind, value = "equ", fmt.Sprint(prog.Constants[name])
+		}
+		fmt.Fprintf(w, "%-20s %-6s %-6s %s\n", name, kind, value, prog.formatLocation(prog.Definitions[name]))
+	}
+}
+
+// writeCrossReference writes every location where a lable or constant is used.
+func (prog *Program) writeCrossReference(w io.Writer) {
+	fmt.Fprintf(w, "\nCross reference:\n\n")
+	for _, name := range prog.symbolNames() {
+		refs := prog.References[name]
+		if len(refs) == 0 {
+			fmt.Fprintf(w, "%-20s (unused)\n", name)
+			continue
+		}
+
+		var uses []string
+		for _, loc := range refs {
+			uses = append(uses, prog.formatLocation(loc))
+		}
+		fmt.Fprintf(w, "%-20s %s\n", name, strings.Join(uses, " "))
+	}
+}
+
+// WriteSymbols writes the address and name of every lable to w, sorted by address.
+func (prog *Program) WriteSymbols(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range prog.sortedLables() {
+		fmt.Fprintf(bw, "$%03X %s\n", s.addr, s.name)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,108 @@
+/*
+Copyright (C) 2016-2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package assembler
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteListing(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"game.asm": `    include "a/util.asm"
+    include "b/util.asm"
+SIZE    equ     5
+UNUSED  equ     1
+Start:
+    jump    Start
+    draw    v0 v1 SIZE
+    ret
+    .       1 2 3 4 5
+`,
+		"a/util.asm": `A:
+    clr
+`,
+		"b/util.asm": `    macro   ret
+    rts
+    endm
+B:
+    loadi   A
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	binary, prog, diags := assembleFile(t, filepath.Join(dir, "game.asm"))
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+
+	var listing bytes.Buffer
+	if err := prog.WriteListing(&listing, binary); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `                                 A:
+0200  00 E0        a/util.asm:2      clr
+                                 B:
+0202  A2 00        b/util.asm:5      loadi   A
+                                 Start:
+0204  12 04        game.asm:6        jump    Start
+0206  D0 15        game.asm:7        draw    v0 v1 SIZE
+0208  00 EE        game.asm:8        ret                 ; rts
+020A  01 02 03 04  game.asm:9        .       1 2 3 4 5
+020E  05           game.asm:9
+
+Symbols:
+
+A                    lable  $200   a/util.asm:1
+B                    lable  $202   b/util.asm:4
+SIZE                 equ    5      game.asm:3
+Start                lable  $204   game.asm:5
+UNUSED               equ    1      game.asm:4
+
+Cross reference:
+
+A                    b/util.asm:5
+B                    (unused)
+SIZE                 game.asm:7
+Start                game.asm:6
+UNUSED               (unused)
+`
+	if got := listing.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteSymbols(t *testing.T) {
+	var buf buffer
+	prog, diags := Assemble("test.asm", strings.NewReader("Start:\nclr\nEnd:\njump Start\nMid equ 3"), &buf, Options{})
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+
+	var symbols bytes.Buffer
+	if err := prog.WriteSymbols(&symbols); err != nil {
+		t.Fatal(err)
+	}
+	if want := "$200 Start\n$202 End\n"; symbols.String() != want {
+		t.Errorf("got %q, want %q", symbols.String(), want)
+	}
+}
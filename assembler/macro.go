@@ -0,0 +1,193 @@
+/*
+Copyright (C) 2016-2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+const maxMacroDepth = 16
+
+type macroLine struct {
+	text string
+	line int
+}
+
+type macro struct {
+	name,
+	file string
+	params []string
+	body   []macroLine
+	valid  bool
+}
+
+// substitute replaces parameters in text with the arguments of the
+// invocation and makes local lables (names starting with '@') unique.
+func (m *macro) substitute(text string, args []string, expansion int) string {
+	var out strings.Builder
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '$' || c == '%':
+			// Skip numeric literals so "$ff" is never mistaken for a parameter named "ff".
+			j := i + 1
+			for j < len(text) && isDigit(text[j], c) {
+				j++
+			}
+			out.WriteString(text[i:j])
+			i = j
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(text) && isSymbolChar(text[j], false) {
+				j++
+			}
+			out.WriteString(text[i:j])
+			i = j
+		case isSymbolChar(c, true):
+			j := i + 1
+			for j < len(text) && isSymbolChar(text[j], false) {
+				j++
+			}
+
+			name := text[i:j]
+			if name[0] == '@' {
+				name = fmt.Sprintf("%s.%d", name, expansion)
+			} else {
+				for p, param := range m.params {
+					if name == param {
+						name = args[p]
+						break
+					}
+				}
+			}
+
+			out.WriteString(name)
+			i = j
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String()
+}
+
+func isDigit(c, prefix byte) bool {
+	switch prefix {
+	case '$':
+		return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+	case '%':
+		return c == '0' || c == '1'
+	}
+	return false
+}
+
+func (asm *assembler) beginMacro(args []string) {
+	m := &macro{file: asm.file}
+	asm.macro = m
+
+	if len(args) < 2 {
+		asm.argErrorf(args, 0, CodeOperandCount, "macro without name")
+		return
+	}
+
+	m.name, m.params = args[1], args[2:]
+	m.valid = asm.checkName("macro", args, 1)
+	if kind := reservedName(m.name); kind != "" && m.valid {
+		asm.argErrorf(args, 1, CodeRedefinition, "macro '%s' redefines %s", m.name, kind)
+		m.valid = false
+	}
+
+	for i, param := range m.params {
+		if param[0] == '@' || !isSymbolChar(param[0], true) {
+			asm.argErrorf(args, i+2, CodeMacro, "invalid parameter '%s' for macro '%s'", param, m.name)
+			m.valid = false
+		}
+	}
+}
+
+// reservedName returns what name is if it is a mnemonic or directive, which
+// a macro may not replace, or else an empty string. Case does not matter,
+// as it does not for mnemonics.
+func reservedName(name string) string {
+	name = strings.ToLower(name)
+	if _, ok := operandCount[name]; ok {
+		return "mnemonic"
+	}
+	for _, d := range directives {
+		if name == d {
+			return "directive"
+		}
+	}
+	return ""
+}
+
+func (asm *assembler) endMacro() {
+	m := asm.macro
+	asm.macro = nil
+
+	if m.valid {
+		asm.macros[m.name] = m
+	}
+}
+
+// recordMacroLine stores a line of the macro currently being defined.
+func (asm *assembler) recordMacroLine(args []string, text string) {
+	switch strings.ToLower(args[0]) {
+	case "endm":
+		asm.checkLen(args, 1)
+		asm.endMacro()
+	case "macro":
+		asm.argErrorf(args, 0, CodeMacro, "nested macro definition inside '%s'", asm.macro.name)
+	default:
+		asm.macro.body = append(asm.macro.body, macroLine{text, asm.line})
+	}
+}
+
+func (asm *assembler) expandMacro(m *macro, args []string) {
+	if len(args)-1 != len(m.params) {
+		asm.argErrorf(args, 0, CodeOperandCount, "macro '%s' expects %d argument(s), got %d", m.name, len(m.params), len(args)-1)
+		return
+	}
+	if asm.macroOverflow {
+		return
+	}
+	if asm.macroDepth >= maxMacroDepth {
+		asm.argErrorf(args, 0, CodeMacro, "macro '%s' nested deeper than %d levels", m.name, maxMacroDepth)
+		asm.macroOverflow = true
+		return
+	}
+
+	asm.expansions++
+	expansion := asm.expansions
+	asm.macroDepth++
+	prevMacroFile, prevMacroLine := asm.macroFile, asm.macroLine
+
+	for _, l := range m.body {
+		asm.macroFile, asm.macroLine = m.file, l.line
+		asm.assembleLine(m.substitute(l.text, args[1:], expansion))
+	}
+
+	asm.macroFile, asm.macroLine = prevMacroFile, prevMacroLine
+	asm.macroDepth--
+
+	if asm.macroDepth == 0 {
+		asm.macroOverflow = false
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright (C) 2016-2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package assembler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMacroExpansion(t *testing.T) {
+	var buf buffer
+	prog, diags := Assemble("test.asm", strings.NewReader(`
+    macro   set r ff
+    load    r ff
+    add     r $ff
+    endm
+
+    set     v1 5
+    set     vA (1 + 2)
+`), &buf, Options{})
+	if HasErrors(diags) {
+		t.Fatal(diags)
+	}
+
+	// $ff is a number, not the parameter ff.
+	want := []byte{0x61, 0x05, 0x71, 0xFF, 0x6A, 0x03, 0x7A, 0xFF}
+	if !bytes.Equal(buf.data, want) {
+		t.Errorf("got % X, want % X", buf.data, want)
+	}
+
+	// Expanded code is at the invocation, in the macro definition.
+	if loc := prog.Lines[2]; loc.Line != 7 || loc.MacroLine != 4 || loc.MacroFile != "test.asm" {
+		t.Errorf("second instruction at %+v, want line 7, macro line 4", loc)
+	}
+}
+
+func TestMacroLocalLables(t *testing.T) {
+	var buf buffer
+	prog, diags := Assemble("test.asm", strings.NewReader(`
+    macro   wait
+@loop:
+    moved   v0
+    skne    v0 0
+    jump    @loop
+    endm
+
+    wait
+    wait
+`), &buf, Options{})
+	if HasErrors(diags) {
+		t.Fatal(diags)
+	}
+
+	want := []byte{0xF0, 0x07, 0x40, 0x00, 0x12, 0x00, 0xF0, 0x07, 0x40, 0x00, 0x12, 0x06}
+	if !bytes.Equal(buf.data, want) {
+		t.Errorf("got % X, want % X", buf.data, want)
+	}
+	if len(prog.Lables) != 2 || prog.Lables["@loop.1"] != 0x200 || prog.Lables["@loop.2"] != 0x206 {
+		t.Errorf("lables %v, want @loop.1 and @loop.2", prog.Lables)
+	}
+}
+
+// nestedMacros returns source with n macros, each invoking the next, and
+// an invocation of the first.
+func nestedMacros(n int) string {
+	var source []string
+	for i := 1; i <= n; i++ {
+		body := fmt.Sprintf("m%d", i+1)
+		if i == n {
+			body = "clr"
+		}
+		source = append(source, fmt.Sprintf("macro m%d\n%s\nendm", i, body))
+	}
+	return strings.Join(append(source, "m1"), "\n")
+}
+
+func TestMacroDepth(t *testing.T) {
+	if prog := opcodes(t, nestedMacros(maxMacroDepth)); !bytes.Equal(prog, []byte{0x00, 0xE0}) {
+		t.Errorf("got % X, want 00 E0", prog)
+	}
+
+	_, diags := assemble(t, nestedMacros(maxMacroDepth+1))
+	if len(diags) != 1 || diags[0].Code != CodeMacro || !strings.Contains(diags[0].Message, "nested deeper than 16 levels") {
+		t.Errorf("got %v, want one depth error", diags)
+	}
+
+	// Recursion stops at the limit with a single error.
+	_, diags = assemble(t, "macro r\nr\nr\nendm\nr")
+	if len(diags) != 1 || diags[0].Code != CodeMacro {
+		t.Errorf("recursion: got %v, want one depth error", diags)
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		code   string
+		line   int
+	}{
+		{"macro m a b\nendm\nm v1", CodeOperandCount, 3},
+		{"macro m a b\nendm\nm 1 2 3", CodeOperandCount, 3},
+		{"macro m\nendm\nm 1", CodeOperandCount, 3},
+		{"macro", CodeOperandCount, 1},
+		{"macro m\nendm\nmacro m\nendm", CodeRedefinition, 3},
+		{"m equ 1\nmacro m\nendm", CodeRedefinition, 2},
+		{"macro draw x\nendm", CodeRedefinition, 1},
+		{"macro JUMP\nendm", CodeRedefinition, 1},
+		{"macro include\nendm", CodeRedefinition, 1},
+		{"macro m @a\nendm", CodeMacro, 1},
+		{"macro m 1a\nendm", CodeMacro, 1},
+		{"macro m\nmacro n\nendm", CodeMacro, 2},
+		{"endm", CodeMacro, 1},
+		{"macro m\nclr", CodeMacro, 3},
+	}
+
+	for _, test := range tests {
+		expectDiagnostic(t, test.source, test.code, test.line)
+	}
+
+	// A rejected macro does not replace the instruction.
+	prog, _ := assemble(t, "macro draw x\nclr\nendm\ndraw v0 v1 5")
+	if !bytes.Equal(prog, []byte{0xD0, 0x15}) {
+		t.Errorf("draw assembled to % X, want D0 15", prog)
+	}
+}
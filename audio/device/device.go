@@ -0,0 +1,29 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package device plays sound on the audio device of the system. It is
+// supported on Windows and macOS. Elsewhere Open fails, and the sound is
+// played through an audio player with audio.NewCommand instead.
+package device
+
+import "errors"
+
+// ErrUnsupported is returned by Open on systems without device support.
+var ErrUnsupported = errors.New("audio device is not supported on this system")
+
+// latency is the length of the device buffer, in seconds.
+const latency = 0.05
@@ -0,0 +1,64 @@
+//go:build windows || darwin
+// +build windows darwin
+
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package device
+
+import (
+	"encoding/binary"
+
+	"github.com/hajimehoshi/oto"
+
+	"github.com/andreas-jonsson/chip8studio/audio"
+)
+
+// Sink writes samples to the audio device. Writes block while the device
+// is busy, so the sink plays in real time.
+type Sink struct {
+	context *oto.Context
+	player  *oto.Player
+	buf     []byte
+}
+
+// Open opens the audio device for mono sound at sampleRate.
+func Open(sampleRate int) (audio.Sink, error) {
+	context, err := oto.NewContext(sampleRate, 1, 2, int(float64(sampleRate)*latency)*2)
+	if err != nil {
+		return nil, err
+	}
+	return &Sink{context: context, player: context.NewPlayer()}, nil
+}
+
+func (s *Sink) Write(samples []int16) error {
+	if n := len(samples) * 2; cap(s.buf) < n {
+		s.buf = make([]byte, n)
+	}
+	s.buf = s.buf[:len(samples)*2]
+
+	for i, v := range samples {
+		binary.LittleEndian.PutUint16(s.buf[i*2:], uint16(v))
+	}
+	_, err := s.player.Write(s.buf)
+	return err
+}
+
+func (s *Sink) Close() error {
+	s.player.Close()
+	return s.context.Close()
+}
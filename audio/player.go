@@ -0,0 +1,62 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package audio
+
+const chunkSize = 512
+
+// Player streams a tone to a real time sink, like Command, from its own
+// goroutine. Sinks that do not block, like Null and WAV, should instead be
+// written with the samples of every emulated frame.
+type Player struct {
+	done chan struct{}
+	err  chan error
+}
+
+// NewPlayer starts playing tone to sink.
+func NewPlayer(tone *Tone, sink Sink) *Player {
+	p := &Player{
+		done: make(chan struct{}),
+		err:  make(chan error, 1),
+	}
+
+	go func() {
+		samples := make([]int16, chunkSize)
+		for {
+			select {
+			case <-p.done:
+				p.err <- sink.Close()
+				return
+			default:
+			}
+
+			tone.Generate(samples)
+			if err := sink.Write(samples); err != nil {
+				sink.Close()
+				p.err <- err
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Close stops the player and closes the sink.
+func (p *Player) Close() error {
+	close(p.done)
+	return <-p.err
+}
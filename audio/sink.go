@@ -0,0 +1,169 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+)
+
+// Sink receives mono 16 bit samples.
+type Sink interface {
+	Write(samples []int16) error
+	Close() error
+}
+
+// Null discards all samples.
+type Null struct{}
+
+func (Null) Write([]int16) error {
+	return nil
+}
+
+func (Null) Close() error {
+	return nil
+}
+
+// WAV writes samples to a WAV file.
+type WAV struct {
+	w          io.WriteSeeker
+	sampleRate int
+	size       uint32
+}
+
+const wavHeaderSize = 44
+
+// NewWAV returns a sink writing a WAV file to w. The sizes in the header are
+// written when the sink is closed.
+func NewWAV(w io.WriteSeeker, sampleRate int) (*WAV, error) {
+	s := &WAV{w: w, sampleRate: sampleRate}
+	return s, s.writeHeader()
+}
+
+func (s *WAV) writeHeader() error {
+	header := struct {
+		Riff          [4]byte
+		RiffSize      uint32
+		Wave          [4]byte
+		Fmt           [4]byte
+		FmtSize       uint32
+		Format        uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		Data          [4]byte
+		DataSize      uint32
+	}{
+		Riff:          [4]byte{'R', 'I', 'F', 'F'},
+		RiffSize:      wavHeaderSize - 8 + s.size,
+		Wave:          [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:           [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		Format:        1,
+		Channels:      1,
+		SampleRate:    uint32(s.sampleRate),
+		ByteRate:      uint32(s.sampleRate) * 2,
+		BlockAlign:    2,
+		BitsPerSample: 16,
+		Data:          [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      s.size,
+	}
+
+	if _, err := s.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return binary.Write(s.w, binary.LittleEndian, &header)
+}
+
+func (s *WAV) Write(samples []int16) error {
+	if err := binary.Write(s.w, binary.LittleEndian, samples); err != nil {
+		return err
+	}
+	s.size += uint32(len(samples) * 2)
+	return nil
+}
+
+// Close writes the final sizes to the header. It does not close the
+// underlying writer.
+func (s *WAV) Close() error {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	_, err := s.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// Command plays samples by writing them as raw little-endian PCM to the
+// standard input of an audio player.
+type Command struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// players are tried in order by NewCommand.
+var players = []struct {
+	name string
+	args func(rate string) []string
+}{
+	{"paplay", func(rate string) []string {
+		return []string{"--raw", "--format=s16le", "--channels=1", "--rate=" + rate, "--latency-msec=50"}
+	}},
+	{"aplay", func(rate string) []string {
+		return []string{"-q", "-t", "raw", "-f", "S16_LE", "-c", "1", "-r", rate, "-B", "50000"}
+	}},
+}
+
+// ErrNoPlayer is returned by NewCommand if no audio player is installed.
+var ErrNoPlayer = errors.New("no audio player found")
+
+// NewCommand starts the first audio player found. Writes block while the
+// player is busy, so the sink plays in real time.
+func NewCommand(sampleRate int) (*Command, error) {
+	for _, p := range players {
+		path, err := exec.LookPath(p.name)
+		if err != nil {
+			continue
+		}
+
+		cmd := exec.Command(path, p.args(strconv.Itoa(sampleRate))...)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("%s: %v", p.name, err)
+		}
+		return &Command{cmd, stdin}, nil
+	}
+	return nil, ErrNoPlayer
+}
+
+func (s *Command) Write(samples []int16) error {
+	return binary.Write(s.stdin, binary.LittleEndian, samples)
+}
+
+func (s *Command) Close() error {
+	s.stdin.Close()
+	return s.cmd.Wait()
+}
@@ -0,0 +1,128 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package audio
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	DefaultSampleRate = 44100
+	DefaultVolume     = 0.25
+	DefaultPitch      = 440
+
+	// XO-CHIP plays patterns at 4000 bits per second at pitch 64.
+	patternRate  = 4000
+	patternPitch = 64
+)
+
+// Tone generates the sound of the machine: a square wave while the sound
+// timer runs, or the XO-CHIP audio pattern if the program has loaded one.
+// It is safe to use from several goroutines.
+type Tone struct {
+	sync.Mutex
+
+	SampleRate int
+
+	// Volume is between 0 and 1.
+	Volume float64
+
+	// Pitch is the frequency of the square wave in Hz. It does not affect
+	// audio patterns, which have their own pitch.
+	Pitch float64
+
+	on         bool
+	hasPattern bool
+	pattern    [16]byte
+	rate       float64
+	phase      float64
+}
+
+func NewTone() *Tone {
+	return &Tone{
+		SampleRate: DefaultSampleRate,
+		Volume:     DefaultVolume,
+		Pitch:      DefaultPitch,
+		rate:       patternRate,
+	}
+}
+
+// Start starts the tone.
+func (t *Tone) Start() {
+	t.Lock()
+	t.on = true
+	t.Unlock()
+}
+
+// Stop stops the tone.
+func (t *Tone) Stop() {
+	t.Lock()
+	t.on = false
+	t.Unlock()
+}
+
+// SetPattern sets the XO-CHIP audio pattern, 128 one bit samples played in
+// a loop, and its pitch register. With ok false the plain square wave is
+// played instead.
+func (t *Tone) SetPattern(pattern [16]byte, pitch byte, ok bool) {
+	t.Lock()
+	t.pattern, t.hasPattern = pattern, ok
+	t.rate = patternRate * math.Pow(2, (float64(pitch)-patternPitch)/48)
+	t.Unlock()
+}
+
+// Generate fills samples with the tone, continuing where the previous call
+// ended.
+func (t *Tone) Generate(samples []int16) {
+	t.Lock()
+	defer t.Unlock()
+
+	amplitude := math.Max(0, math.Min(1, t.Volume)) * math.MaxInt16
+	if !t.on || amplitude == 0 {
+		for i := range samples {
+			samples[i] = 0
+		}
+		return
+	}
+
+	// The phase counts bits of the pattern, or half periods of the square
+	// wave, so both are played the same way.
+	step := 2 * t.Pitch / float64(t.SampleRate)
+	if t.hasPattern {
+		step = t.rate / float64(t.SampleRate)
+	}
+
+	for i := range samples {
+		var high bool
+		if t.hasPattern {
+			bit := int(t.phase) % 128
+			high = t.pattern[bit/8]&(0x80>>uint(bit%8)) != 0
+		} else {
+			high = int(t.phase)%2 == 0
+		}
+
+		if high {
+			samples[i] = int16(amplitude)
+		} else {
+			samples[i] = -int16(amplitude)
+		}
+
+		t.phase = math.Mod(t.phase+step, 128)
+	}
+}
@@ -0,0 +1,676 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package chip8 is the CPU core the studio runs programs on. It takes the
+// place of the core in github.com/andreas-jonsson/chip8, which runs a
+// program a frame at a time and keeps the machine state to itself. The
+// debugger has to run single instructions, see every memory access and
+// snapshot the machine, so the core lives in the studio, next to the
+// debugger that depends on those hooks.
+package chip8
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+const (
+	MemorySize   = 0x1000
+	ProgramStart = 0x200
+	StackSize    = 16
+
+	// FrameRate is the rate of the timers, in Hz. The display is drawn
+	// once per frame.
+	FrameRate = 60
+
+	fontAddr     = 0x000
+	bigFontAddr  = 0x050
+	defaultPitch = 64
+)
+
+const (
+	LowResWidth   = 64
+	LowResHeight  = 32
+	HighResWidth  = 128
+	HighResHeight = 64
+)
+
+// Machine is the host the system runs on.
+type Machine interface {
+	Load(memory []byte)
+	Rand() *rand.Rand
+	BeginTone()
+	EndTone()
+	Key(code int) bool
+	SetCPUFrequency(freq int)
+	ResizeVideo(width int)
+	Draw(video []byte)
+}
+
+// Registers is the state of the CPU.
+type Registers struct {
+	V     [16]byte
+	I     uint16
+	PC    uint16
+	Stack [StackSize]uint16
+	SP    int
+	DT    byte
+	ST    byte
+	RPL   [8]byte
+}
+
+// AudioMachine is implemented by machines that can play XO-CHIP audio
+// patterns. The pattern is only valid if ok is true.
+type AudioMachine interface {
+	SetAudioPattern(pattern [16]byte, pitch byte, ok bool)
+}
+
+// State is everything that changes while a program runs. It is a plain
+// value, so copying it takes a snapshot of the machine.
+type State struct {
+	Registers
+	Memory [MemorySize]byte
+
+	// Frames counts the 60 Hz timer ticks since reset.
+	Frames uint64
+
+	HighRes bool
+	Halted  bool
+
+	// VBlankWait is set by draw with QuirkVBlank. No instructions are run
+	// until the next Tick.
+	VBlankWait bool
+
+	// Video holds one byte per pixel, 0 or 1, in rows of Width() pixels.
+	Video [HighResWidth * HighResHeight]byte
+
+	// Background and foreground color, as indexes in the Plan 9 palette.
+	BG, FG byte
+
+	// XO-CHIP audio pattern and pitch register.
+	Pattern    [16]byte
+	HasPattern bool
+	Pitch      byte
+
+	// CPUSpeed is the speed set by the program with system call $100, in
+	// instructions per second, or zero if it has not set one.
+	CPUSpeed int
+}
+
+func (st *State) Width() int {
+	if st.HighRes {
+		return HighResWidth
+	}
+	return LowResWidth
+}
+
+func (st *State) Height() int {
+	if st.HighRes {
+		return HighResHeight
+	}
+	return LowResHeight
+}
+
+type System struct {
+	State
+
+	// OnAccess, if set, is called for every memory access made by an
+	// instruction. Instruction fetches are not included.
+	OnAccess func(addr uint16, write bool)
+
+	// SpriteAddr, SpriteWidth and SpriteSize are the address, width in
+	// pixels and size in bytes of the last sprite drawn.
+	SpriteAddr  uint16
+	SpriteWidth int
+	SpriteSize  int
+
+	// Quirks selects the behavior of instructions that implementations
+	// disagree on.
+	Quirks Quirks
+
+	machine Machine
+	rand    *rand.Rand
+	invalid bool
+	toneOn  bool
+	video   []byte
+}
+
+var font = [...]byte{
+	0xF0, 0x90, 0x90, 0x90, 0xF0, 0x20, 0x60, 0x20, 0x20, 0x70,
+	0xF0, 0x10, 0xF0, 0x80, 0xF0, 0xF0, 0x10, 0xF0, 0x10, 0xF0,
+	0x90, 0x90, 0xF0, 0x10, 0x10, 0xF0, 0x80, 0xF0, 0x10, 0xF0,
+	0xF0, 0x80, 0xF0, 0x90, 0xF0, 0xF0, 0x10, 0x20, 0x40, 0x40,
+	0xF0, 0x90, 0xF0, 0x90, 0xF0, 0xF0, 0x90, 0xF0, 0x10, 0xF0,
+	0xF0, 0x90, 0xF0, 0x90, 0x90, 0xE0, 0x90, 0xE0, 0x90, 0xE0,
+	0xF0, 0x80, 0x80, 0x80, 0xF0, 0xE0, 0x90, 0x90, 0x90, 0xE0,
+	0xF0, 0x80, 0xF0, 0x80, 0xF0, 0xF0, 0x80, 0xF0, 0x80, 0x80,
+}
+
+var bigFont = [...]byte{
+	0x3C, 0x7E, 0xE7, 0xC3, 0xC3, 0xC3, 0xC3, 0xE7, 0x7E, 0x3C,
+	0x18, 0x38, 0x58, 0x18, 0x18, 0x18, 0x18, 0x18, 0x18, 0x3C,
+	0x3E, 0x7F, 0xC3, 0x06, 0x0C, 0x18, 0x30, 0x60, 0xFF, 0xFF,
+	0x3C, 0x7E, 0xC3, 0x03, 0x0E, 0x0E, 0x03, 0xC3, 0x7E, 0x3C,
+	0x06, 0x0E, 0x1E, 0x36, 0x66, 0xC6, 0xFF, 0xFF, 0x06, 0x06,
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFC, 0xFE, 0x03, 0xC3, 0x7E, 0x3C,
+	0x3E, 0x7C, 0xC0, 0xC0, 0xFC, 0xFE, 0xC3, 0xC3, 0x7E, 0x3C,
+	0xFF, 0xFF, 0x03, 0x06, 0x0C, 0x18, 0x30, 0x60, 0x60, 0x60,
+	0x3C, 0x7E, 0xC3, 0xC3, 0x7E, 0x7E, 0xC3, 0xC3, 0x7E, 0x3C,
+	0x3C, 0x7E, 0xC3, 0xC3, 0x7F, 0x3F, 0x03, 0x03, 0x3E, 0x7C,
+	0x7E, 0xFF, 0xC3, 0xC3, 0xC3, 0xFF, 0xFF, 0xC3, 0xC3, 0xC3,
+	0xFC, 0xFC, 0xC3, 0xC3, 0xFC, 0xFC, 0xC3, 0xC3, 0xFC, 0xFC,
+	0x3C, 0xFF, 0xC3, 0xC0, 0xC0, 0xC0, 0xC0, 0xC3, 0xFF, 0x3C,
+	0xFC, 0xFE, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xFE, 0xFC,
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF,
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFF, 0xFF, 0xC0, 0xC0, 0xC0, 0xC0,
+}
+
+// NewSystem returns a system running on m, reset and ready to run the
+// program m loads.
+func NewSystem(m Machine) *System {
+	s := &System{machine: m}
+	s.Reset()
+	return s
+}
+
+// Reset clears the machine, loads the program and starts it from the
+// beginning.
+func (s *System) Reset() {
+	s.State = State{Registers: Registers{PC: ProgramStart}, FG: 0xFF, Pitch: defaultPitch}
+	copy(s.Memory[fontAddr:], font[:])
+	copy(s.Memory[bigFontAddr:], bigFont[:])
+
+	s.machine.Load(s.Memory[ProgramStart:])
+	s.machine.ResizeVideo(LowResWidth)
+	s.rand = s.machine.Rand()
+	s.setTone(false)
+	s.updateAudio()
+	s.invalid = true
+}
+
+// SetState replaces the state of the machine, as when loading a snapshot.
+// The machine is given the speed of the state, if the program set one.
+func (s *System) SetState(st State) {
+	s.State = st
+	if s.CPUSpeed != 0 {
+		s.machine.SetCPUFrequency(s.CPUSpeed)
+	}
+	s.machine.ResizeVideo(s.Width())
+	s.setTone(s.ST > 0)
+	s.updateAudio()
+	s.invalid = true
+}
+
+// Invalid returns true if the display has changed since the last Refresh.
+func (s *System) Invalid() bool {
+	return s.invalid
+}
+
+// Invalidate forces the next Refresh to draw the display.
+func (s *System) Invalidate() {
+	s.invalid = true
+}
+
+// Refresh draws the display, if it has changed.
+func (s *System) Refresh() {
+	if !s.invalid {
+		return
+	}
+	s.invalid = false
+
+	n := s.Width() * s.Height()
+	if cap(s.video) < n {
+		s.video = make([]byte, n)
+	}
+	s.video = s.video[:n]
+
+	for i, p := range s.Video[:n] {
+		if p != 0 {
+			s.video[i] = s.FG
+		} else {
+			s.video[i] = s.BG
+		}
+	}
+	s.machine.Draw(s.video)
+}
+
+func (s *System) setTone(on bool) {
+	if on == s.toneOn {
+		return
+	}
+	s.toneOn = on
+
+	if on {
+		s.machine.BeginTone()
+	} else {
+		s.machine.EndTone()
+	}
+}
+
+func (s *System) updateAudio() {
+	if m, ok := s.machine.(AudioMachine); ok {
+		m.SetAudioPattern(s.Pattern, s.Pitch, s.HasPattern)
+	}
+}
+
+// Tick ends a frame. It counts down the delay and sound timers, and lets
+// a program waiting for the next frame continue.
+func (s *System) Tick() {
+	s.Frames++
+	if s.DT > 0 {
+		s.DT--
+	}
+	if s.ST > 0 {
+		s.ST--
+	}
+	s.setTone(s.ST > 0)
+	s.VBlankWait = false
+}
+
+func (s *System) read(addr uint16) byte {
+	addr %= MemorySize
+	if s.OnAccess != nil {
+		s.OnAccess(addr, false)
+	}
+	return s.Memory[addr]
+}
+
+func (s *System) write(addr uint16, b byte) {
+	addr %= MemorySize
+	if s.OnAccess != nil {
+		s.OnAccess(addr, true)
+	}
+	s.Memory[addr] = b
+}
+
+// Opcode returns the instruction at addr.
+func (s *System) Opcode(addr uint16) uint16 {
+	return uint16(s.Memory[addr%MemorySize])<<8 | uint16(s.Memory[(addr+1)%MemorySize])
+}
+
+func (s *System) invalidOpcode(op uint16) error {
+	return fmt.Errorf("invalid opcode $%04X at $%03X", op, s.PC-2)
+}
+
+// Step executes one instruction. Nothing is done while the machine is
+// halted or waiting for the next frame. The timers are run by Tick.
+func (s *System) Step() error {
+	if s.Halted || s.VBlankWait {
+		return nil
+	}
+
+	op := s.Opcode(s.PC)
+	s.PC += 2
+
+	var (
+		x   = (op >> 8) & 0xF
+		y   = (op >> 4) & 0xF
+		n   = op & 0xF
+		nn  = byte(op)
+		nnn = op & 0xFFF
+	)
+
+	switch op >> 12 {
+	case 0x0:
+		switch {
+		case op == 0x00E0:
+			s.clear()
+		case op == 0x00EE:
+			if s.SP == 0 {
+				return fmt.Errorf("stack underflow at $%03X", s.PC-2)
+			}
+			s.SP--
+			s.PC = s.Stack[s.SP]
+		case op&0xFFF0 == 0x00C0:
+			s.scroll(0, int(n))
+		case op == 0x00FB:
+			s.scroll(4, 0)
+		case op == 0x00FC:
+			s.scroll(-4, 0)
+		case op == 0x00FD:
+			s.Halted = true
+		case op == 0x00FE:
+			s.setHighRes(false)
+		case op == 0x00FF:
+			s.setHighRes(true)
+		default:
+			return s.syscall(nnn)
+		}
+	case 0x1:
+		s.PC = nnn
+	case 0x2:
+		if s.SP == StackSize {
+			return fmt.Errorf("stack overflow at $%03X", s.PC-2)
+		}
+		s.Stack[s.SP] = s.PC
+		s.SP++
+		s.PC = nnn
+	case 0x3:
+		s.skip(s.V[x] == nn)
+	case 0x4:
+		s.skip(s.V[x] != nn)
+	case 0x5:
+		if n != 0 {
+			return s.invalidOpcode(op)
+		}
+		s.skip(s.V[x] == s.V[y])
+	case 0x6:
+		s.V[x] = nn
+	case 0x7:
+		s.V[x] += nn
+	case 0x8:
+		return s.arithmetic(op, x, y, n)
+	case 0x9:
+		if n != 0 {
+			return s.invalidOpcode(op)
+		}
+		s.skip(s.V[x] != s.V[y])
+	case 0xA:
+		s.I = nnn
+	case 0xB:
+		if s.Quirks.Has(QuirkJumpVX) {
+			s.PC = nnn + uint16(s.V[x])
+		} else {
+			s.PC = nnn + uint16(s.V[0])
+		}
+	case 0xC:
+		s.V[x] = byte(s.rand.Intn(256)) & nn
+	case 0xD:
+		s.draw(int(s.V[x]), int(s.V[y]), int(n))
+	case 0xE:
+		switch nn {
+		case 0x9E:
+			s.skip(s.machine.Key(int(s.V[x] & 0xF)))
+		case 0xA1:
+			s.skip(!s.machine.Key(int(s.V[x] & 0xF)))
+		default:
+			return s.invalidOpcode(op)
+		}
+	case 0xF:
+		return s.misc(op, x)
+	}
+	return nil
+}
+
+func (s *System) skip(cond bool) {
+	if cond {
+		s.PC += 2
+	}
+}
+
+func (s *System) arithmetic(op, x, y, n uint16) error {
+	vx, vy := s.V[x], s.V[y]
+	if (n == 0x6 || n == 0xE) && s.Quirks.Has(QuirkShiftVy) {
+		vx = vy
+	}
+
+	switch n {
+	case 0x0:
+		s.V[x] = vy
+	case 0x1:
+		s.V[x] = vx | vy
+		s.vfReset()
+	case 0x2:
+		s.V[x] = vx & vy
+		s.vfReset()
+	case 0x3:
+		s.V[x] = vx ^ vy
+		s.vfReset()
+	case 0x4:
+		sum := int(vx) + int(vy)
+		s.V[x] = byte(sum)
+		s.V[0xF] = flag(sum > 0xFF)
+	case 0x5:
+		s.V[x] = vx - vy
+		s.V[0xF] = flag(vx >= vy)
+	case 0x6:
+		s.V[x] = vx >> 1
+		s.V[0xF] = vx & 1
+	case 0x7:
+		s.V[x] = vy - vx
+		s.V[0xF] = flag(vy >= vx)
+	case 0xE:
+		s.V[x] = vx << 1
+		s.V[0xF] = vx >> 7
+	default:
+		return s.invalidOpcode(op)
+	}
+	return nil
+}
+
+func (s *System) loadStoreI(x uint16) {
+	switch {
+	case s.Quirks.Has(QuirkLoadStoreI):
+		s.I += x + 1
+	case s.Quirks.Has(QuirkLoadStoreX):
+		s.I += x
+	}
+}
+
+func (s *System) vfReset() {
+	if s.Quirks.Has(QuirkVFReset) {
+		s.V[0xF] = 0
+	}
+}
+
+func flag(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (s *System) misc(op, x uint16) error {
+	switch op & 0xFF {
+	case 0x02:
+		if x != 0 {
+			return s.invalidOpcode(op)
+		}
+		for i := range s.Pattern {
+			s.Pattern[i] = s.read(s.I + uint16(i))
+		}
+		s.HasPattern = true
+		s.updateAudio()
+	case 0x07:
+		s.V[x] = s.DT
+	case 0x0A:
+		for k := 0; k < 16; k++ {
+			if s.machine.Key(k) {
+				s.V[x] = byte(k)
+				return nil
+			}
+		}
+		s.PC -= 2
+	case 0x15:
+		s.DT = s.V[x]
+	case 0x18:
+		s.ST = s.V[x]
+		s.setTone(s.ST > 0)
+	case 0x1E:
+		s.I += uint16(s.V[x])
+	case 0x29:
+		s.I = fontAddr + uint16(s.V[x]&0xF)*5
+	case 0x30:
+		s.I = bigFontAddr + uint16(s.V[x]&0xF)*10
+	case 0x3A:
+		s.Pitch = s.V[x]
+		s.updateAudio()
+	case 0x33:
+		v := s.V[x]
+		s.write(s.I, v/100)
+		s.write(s.I+1, v/10%10)
+		s.write(s.I+2, v%10)
+	case 0x55:
+		for r := uint16(0); r <= x; r++ {
+			s.write(s.I+r, s.V[r])
+		}
+		s.loadStoreI(x)
+	case 0x65:
+		for r := uint16(0); r <= x; r++ {
+			s.V[r] = s.read(s.I + r)
+		}
+		s.loadStoreI(x)
+	case 0x75:
+		for r := uint16(0); r <= x && r < 8; r++ {
+			s.RPL[r] = s.V[r]
+		}
+	case 0x85:
+		for r := uint16(0); r <= x && r < 8; r++ {
+			s.V[r] = s.RPL[r]
+		}
+	default:
+		return s.invalidOpcode(op)
+	}
+	return nil
+}
+
+// syscall executes the Chippy system calls.
+func (s *System) syscall(addr uint16) error {
+	switch addr {
+	case 0x100:
+		s.CPUSpeed = int(s.V[0]) * 10
+		s.machine.SetCPUFrequency(s.CPUSpeed)
+	case 0x101:
+		s.Reset()
+	case 0x102:
+		s.BG, s.FG = s.V[0], s.V[1]
+		s.invalid = true
+	default:
+		return fmt.Errorf("unknown syscall $%03X at $%03X", addr, s.PC-2)
+	}
+	return nil
+}
+
+func (s *System) clear() {
+	s.Video = [len(s.Video)]byte{}
+	s.invalid = true
+}
+
+func (s *System) setHighRes(on bool) {
+	s.HighRes = on
+	s.clear()
+	s.machine.ResizeVideo(s.Width())
+}
+
+// scroll moves the display dx pixels right and dy pixels down.
+func (s *System) scroll(dx, dy int) {
+	w, h := s.Width(), s.Height()
+	var video [len(s.Video)]byte
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := x-dx, y-dy
+			if sx >= 0 && sx < w && sy >= 0 && sy < h {
+				video[y*w+x] = s.Video[sy*w+sx]
+			}
+		}
+	}
+
+	s.Video = video
+	s.invalid = true
+}
+
+// draw xors the sprite at I onto the display. Sprites wrap around to the
+// other side of the screen if they start outside it, and are clipped at
+// the edges, or wrapped with QuirkWrap.
+func (s *System) draw(x, y, n int) {
+	w, h := s.Width(), s.Height()
+	x, y = x%w, y%h
+
+	rows, cols := n, 8
+	if n == 0 {
+		if !s.HighRes {
+			return
+		}
+		rows, cols = 16, 16
+	}
+
+	s.V[0xF] = 0
+	wrap := s.Quirks.Has(QuirkWrap)
+	addr := s.I
+	s.SpriteAddr, s.SpriteWidth, s.SpriteSize = addr, cols, rows*cols/8
+
+	for row := 0; row < rows; row++ {
+		var bits uint16
+		if cols == 16 {
+			bits = uint16(s.read(addr))<<8 | uint16(s.read(addr+1))
+			addr += 2
+		} else {
+			bits = uint16(s.read(addr)) << 8
+			addr++
+		}
+
+		py := y + row
+		if py >= h {
+			if !wrap {
+				continue
+			}
+			py -= h
+		}
+
+		for col := 0; col < cols; col++ {
+			px := x + col
+			if bits&(0x8000>>uint(col)) == 0 {
+				continue
+			}
+			if px >= w {
+				if !wrap {
+					continue
+				}
+				px -= w
+			}
+
+			i := py*w + px
+			if s.Video[i] != 0 {
+				s.V[0xF] = 1
+			}
+			s.Video[i] ^= 1
+		}
+	}
+	s.invalid = true
+	s.VBlankWait = s.Quirks.Has(QuirkVBlank)
+}
+
+// Dump writes the registers and stack to w.
+func (s *System) Dump(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s\n\n", name)
+	fmt.Fprintf(w, "PC: $%03X   I: $%03X   SP: %d\n", s.PC, s.I, s.SP)
+	fmt.Fprintf(w, "DT: $%02X    ST: $%02X\n\n", s.DT, s.ST)
+
+	for i, v := range s.V {
+		fmt.Fprintf(w, "V%X: $%02X", i, v)
+		if i%4 == 3 {
+			fmt.Fprintln(w)
+		} else {
+			fmt.Fprint(w, "   ")
+		}
+	}
+
+	fmt.Fprint(w, "\nStack:")
+	for i := 0; i < s.SP; i++ {
+		fmt.Fprintf(w, " $%03X", s.Stack[i])
+	}
+	fmt.Fprintln(w)
+
+	if s.Quirks != 0 {
+		fmt.Fprintf(w, "\nQuirks: %v\n", s.Quirks)
+	}
+	if s.Halted {
+		fmt.Fprintln(w, "\nHalted")
+	}
+}
@@ -0,0 +1,543 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package chip8
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// testMachine runs a program given as opcodes.
+type testMachine struct {
+	program []byte
+	keys    [16]bool
+	tone    bool
+	freq    int
+	width   int
+	frames  int
+}
+
+func newTestMachine(opcodes ...uint16) *testMachine {
+	m := &testMachine{}
+	for _, op := range opcodes {
+		m.program = append(m.program, byte(op>>8), byte(op))
+	}
+	return m
+}
+
+func (m *testMachine) Load(memory []byte)       { copy(memory, m.program) }
+func (m *testMachine) Rand() *rand.Rand         { return rand.New(rand.NewSource(1)) }
+func (m *testMachine) BeginTone()               { m.tone = true }
+func (m *testMachine) EndTone()                 { m.tone = false }
+func (m *testMachine) Key(code int) bool        { return m.keys[code] }
+func (m *testMachine) SetCPUFrequency(freq int) { m.freq = freq }
+func (m *testMachine) ResizeVideo(width int)    { m.width = width }
+func (m *testMachine) Draw(video []byte)        { m.frames++ }
+
+// run creates a system for the opcodes and executes n instructions.
+func run(t *testing.T, n int, opcodes ...uint16) (*System, *testMachine) {
+	t.Helper()
+	m := newTestMachine(opcodes...)
+	s := NewSystem(m)
+	step(t, s, n)
+	return s, m
+}
+
+func step(t *testing.T, s *System, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := s.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadAdd(t *testing.T) {
+	s, _ := run(t, 4, 0x6A12, 0x7A34, 0x6BFF, 0x7B02)
+	if s.V[0xA] != 0x46 {
+		t.Errorf("vA = $%02X, want $46", s.V[0xA])
+	}
+	// 7xnn wraps and leaves vF alone.
+	if s.V[0xB] != 0x01 || s.V[0xF] != 0 {
+		t.Errorf("vB = $%02X, vF = %d, want $01, 0", s.V[0xB], s.V[0xF])
+	}
+	if s.PC != ProgramStart+8 {
+		t.Errorf("PC = $%03X, want $%03X", s.PC, ProgramStart+8)
+	}
+}
+
+func TestJumpCall(t *testing.T) {
+	// $200 call $206, $202 jump $20A, $206 load v0 1, $208 rts
+	s, _ := run(t, 1, 0x2206, 0x120A, 0x0000, 0x6001, 0x00EE)
+	if s.PC != 0x206 || s.SP != 1 || s.Stack[0] != 0x202 {
+		t.Fatalf("after call PC = $%03X SP = %d, want $206 1", s.PC, s.SP)
+	}
+
+	step(t, s, 2)
+	if s.PC != 0x202 || s.SP != 0 || s.V[0] != 1 {
+		t.Fatalf("after rts PC = $%03X SP = %d, want $202 0", s.PC, s.SP)
+	}
+
+	step(t, s, 1)
+	if s.PC != 0x20A {
+		t.Fatalf("after jump PC = $%03X, want $20A", s.PC)
+	}
+}
+
+func TestStackErrors(t *testing.T) {
+	s := NewSystem(newTestMachine(0x00EE))
+	if err := s.Step(); err == nil || !strings.Contains(err.Error(), "underflow") {
+		t.Errorf("rts with empty stack: %v", err)
+	}
+
+	s = NewSystem(newTestMachine(0x2200))
+	step(t, s, StackSize)
+	if err := s.Step(); err == nil || !strings.Contains(err.Error(), "overflow") {
+		t.Errorf("call with full stack: %v", err)
+	}
+}
+
+func TestSkips(t *testing.T) {
+	tests := []struct {
+		name string
+		op   uint16
+		skip bool
+	}{
+		{"ske taken", 0x3005, true},
+		{"ske", 0x3006, false},
+		{"skne taken", 0x4006, true},
+		{"skne", 0x4005, false},
+		{"skre taken", 0x5010, true},
+		{"skre", 0x5020, false},
+		{"sknre taken", 0x9020, true},
+		{"sknre", 0x9010, false},
+		{"skp taken", 0xE19E, true},
+		{"skp", 0xE29E, false},
+		{"sknp taken", 0xE2A1, true},
+		{"sknp", 0xE1A1, false},
+	}
+
+	for _, test := range tests {
+		// v0 = 5, v1 = 5, v2 = 6, with key 5 pressed.
+		m := newTestMachine(0x6005, 0x6105, 0x6206, test.op)
+		m.keys[5] = true
+		s := NewSystem(m)
+		step(t, s, 4)
+
+		want := uint16(ProgramStart + 8)
+		if test.skip {
+			want += 2
+		}
+		if s.PC != want {
+			t.Errorf("%s: PC = $%03X, want $%03X", test.name, s.PC, want)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		name   string
+		x, y   byte
+		op     uint16
+		result byte
+		vf     byte
+	}{
+		{"move", 0x12, 0x34, 0x8010, 0x34, 0xAA},
+		{"or", 0x0F, 0x30, 0x8011, 0x3F, 0xAA},
+		{"and", 0x3C, 0x0F, 0x8012, 0x0C, 0xAA},
+		{"xor", 0xFF, 0x0F, 0x8013, 0xF0, 0xAA},
+		{"addr", 0x10, 0x20, 0x8014, 0x30, 0},
+		{"addr carry", 0xF0, 0x20, 0x8014, 0x10, 1},
+		{"sub", 0x30, 0x10, 0x8015, 0x20, 1},
+		{"sub equal", 0x30, 0x30, 0x8015, 0x00, 1},
+		{"sub borrow", 0x10, 0x30, 0x8015, 0xE0, 0},
+		{"shr", 0x05, 0xFF, 0x8016, 0x02, 1},
+		{"shr even", 0x04, 0xFF, 0x8016, 0x02, 0},
+		{"subr", 0x10, 0x30, 0x8017, 0x20, 1},
+		{"subr borrow", 0x30, 0x10, 0x8017, 0xE0, 0},
+		{"shl", 0x81, 0xFF, 0x801E, 0x02, 1},
+		{"shl", 0x41, 0xFF, 0x801E, 0x82, 0},
+	}
+
+	for _, test := range tests {
+		s, _ := run(t, 4, 0x6000|uint16(test.x), 0x6100|uint16(test.y), 0x6FAA, test.op)
+		if s.V[0] != test.result || s.V[0xF] != test.vf {
+			t.Errorf("%s $%02X $%02X: v0 = $%02X, vF = $%02X, want $%02X, $%02X", test.name, test.x, test.y, s.V[0], s.V[0xF], test.result, test.vf)
+		}
+	}
+}
+
+func TestFlagRegisterResult(t *testing.T) {
+	// When vF is the destination, the flag wins over the result.
+	s, _ := run(t, 3, 0x6FF0, 0x6120, 0x8F14)
+	if s.V[0xF] != 1 {
+		t.Errorf("vF = $%02X, want 1", s.V[0xF])
+	}
+}
+
+func TestIndex(t *testing.T) {
+	s, _ := run(t, 3, 0xA2F0, 0x6010, 0xF01E)
+	if s.I != 0x300 {
+		t.Errorf("I = $%03X, want $300", s.I)
+	}
+
+	s, _ = run(t, 2, 0x600B, 0xF029)
+	if s.I != fontAddr+0xB*5 {
+		t.Errorf("ldspr: I = $%03X, want $%03X", s.I, fontAddr+0xB*5)
+	}
+
+	s, _ = run(t, 2, 0x6007, 0xF030)
+	if s.I != bigFontAddr+7*10 {
+		t.Errorf("ldhspr: I = $%03X, want $%03X", s.I, bigFontAddr+7*10)
+	}
+}
+
+func TestJump0(t *testing.T) {
+	s, _ := run(t, 3, 0x6004, 0x6108, 0xB300)
+	if s.PC != 0x304 {
+		t.Errorf("PC = $%03X, want $304", s.PC)
+	}
+}
+
+func TestRand(t *testing.T) {
+	s, _ := run(t, 1, 0xC00F)
+	want := byte(rand.New(rand.NewSource(1)).Intn(256)) & 0x0F
+	if s.V[0] != want {
+		t.Errorf("v0 = $%02X, want $%02X", s.V[0], want)
+	}
+}
+
+func TestBCD(t *testing.T) {
+	s, _ := run(t, 3, 0x60FE, 0xA300, 0xF033)
+	if got := s.Memory[0x300:0x303]; got[0] != 2 || got[1] != 5 || got[2] != 4 {
+		t.Errorf("bcd 254 = %v, want [2 5 4]", got)
+	}
+	if s.I != 0x300 {
+		t.Errorf("I = $%03X, want $300", s.I)
+	}
+}
+
+func TestStoreRead(t *testing.T) {
+	s, _ := run(t, 5, 0x6011, 0x6122, 0x6233, 0xA300, 0xF255)
+	if got := s.Memory[0x300:0x304]; got[0] != 0x11 || got[1] != 0x22 || got[2] != 0x33 || got[3] != 0 {
+		t.Errorf("stor v2 wrote % X", got)
+	}
+	if s.I != 0x300 {
+		t.Errorf("I = $%03X, want $300", s.I)
+	}
+
+	s, _ = run(t, 2, 0xA200, 0xF165)
+	if s.V[0] != 0xA2 || s.V[1] != 0x00 || s.V[2] != 0 {
+		t.Errorf("read v1: v0-v2 = % X", s.V[:3])
+	}
+}
+
+func TestRPL(t *testing.T) {
+	s, _ := run(t, 4, 0x6011, 0x6122, 0x6233, 0xF275)
+	if s.RPL[0] != 0x11 || s.RPL[1] != 0x22 || s.RPL[2] != 0x33 || s.RPL[3] != 0 {
+		t.Errorf("storr v2: RPL = % X", s.RPL)
+	}
+
+	s.V = [16]byte{}
+	s.PC = ProgramStart
+	s.Memory[ProgramStart], s.Memory[ProgramStart+1] = 0xF1, 0x85
+	step(t, s, 1)
+	if s.V[0] != 0x11 || s.V[1] != 0x22 || s.V[2] != 0 {
+		t.Errorf("readr v1: v0-v2 = % X", s.V[:3])
+	}
+}
+
+func TestTimers(t *testing.T) {
+	s, m := run(t, 3, 0x6003, 0xF015, 0xF018)
+	if s.DT != 3 || s.ST != 3 || !m.tone {
+		t.Fatalf("DT = %d, ST = %d, tone %v, want 3, 3, true", s.DT, s.ST, m.tone)
+	}
+
+	for i := 2; i >= 0; i-- {
+		s.Tick()
+		if int(s.DT) != i || int(s.ST) != i {
+			t.Fatalf("DT = %d, ST = %d, want %d", s.DT, s.ST, i)
+		}
+	}
+	if m.tone {
+		t.Error("tone still on after the sound timer ran out")
+	}
+
+	s.Tick()
+	if s.DT != 0 || s.ST != 0 || s.Frames != 4 {
+		t.Errorf("DT = %d, ST = %d, frames = %d, want 0, 0, 4", s.DT, s.ST, s.Frames)
+	}
+
+	s.PC = ProgramStart
+	s.DT = 0x42
+	s.Memory[ProgramStart], s.Memory[ProgramStart+1] = 0xF5, 0x07
+	step(t, s, 1)
+	if s.V[5] != 0x42 {
+		t.Errorf("moved: v5 = $%02X, want $42", s.V[5])
+	}
+}
+
+func TestKeyWait(t *testing.T) {
+	m := newTestMachine(0xF30A)
+	s := NewSystem(m)
+	step(t, s, 3)
+	if s.PC != ProgramStart {
+		t.Fatalf("keyd did not wait, PC = $%03X", s.PC)
+	}
+
+	m.keys[0xC] = true
+	step(t, s, 1)
+	if s.PC != ProgramStart+2 || s.V[3] != 0xC {
+		t.Errorf("PC = $%03X, v3 = $%02X, want $%03X, $0C", s.PC, s.V[3], ProgramStart+2)
+	}
+}
+
+// pixels returns the display as rows of # and ., for the w by h pixels in
+// the top left corner.
+func pixels(s *System, w, h int) string {
+	var rows []string
+	for y := 0; y < h; y++ {
+		row := make([]byte, w)
+		for x := range row {
+			row[x] = '.'
+			if s.Video[y*s.Width()+x] != 0 {
+				row[x] = '#'
+			}
+		}
+		rows = append(rows, string(row))
+	}
+	return strings.Join(rows, "\n")
+}
+
+func TestDraw(t *testing.T) {
+	// Draw the font sprite for 0 at 1,2.
+	s, _ := run(t, 4, 0x6001, 0x6102, 0xF229, 0xD015)
+	want := strings.Join([]string{
+		"..........",
+		"..........",
+		".####.....",
+		".#..#.....",
+		".#..#.....",
+		".#..#.....",
+		".####.....",
+		"..........",
+	}, "\n")
+	if got := pixels(s, 10, 8); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if s.V[0xF] != 0 {
+		t.Errorf("vF = %d after drawing on a clear display", s.V[0xF])
+	}
+	if s.SpriteAddr != fontAddr || s.SpriteWidth != 8 || s.SpriteSize != 5 {
+		t.Errorf("sprite $%03X, %d wide, %d bytes, want $%03X, 8, 5", s.SpriteAddr, s.SpriteWidth, s.SpriteSize, fontAddr)
+	}
+
+	// Drawing it again erases it and reports the collision.
+	s.PC -= 2
+	step(t, s, 1)
+	if got := pixels(s, 10, 8); strings.Contains(got, "#") {
+		t.Errorf("display not erased:\n%s", got)
+	}
+	if s.V[0xF] != 1 {
+		t.Errorf("vF = %d, want 1", s.V[0xF])
+	}
+}
+
+func TestDrawClip(t *testing.T) {
+	// An 8 pixel wide sprite of ones at 60,30 is cut at the edges.
+	s, _ := run(t, 4, 0x603C, 0x611E, 0xA208, 0xD013, 0xFFFF, 0xFF00)
+	lit := 0
+	for _, p := range s.Video {
+		lit += int(p)
+	}
+	if lit != 4*2 {
+		t.Errorf("%d pixels lit, want 8", lit)
+	}
+	if s.Video[31*LowResWidth+63] != 1 || s.Video[0] != 0 {
+		t.Error("sprite not clipped at the bottom right corner")
+	}
+
+	// Coordinates outside the display wrap before drawing.
+	s, _ = run(t, 4, 0x6041, 0x6121, 0xA208, 0xD011, 0x8000)
+	if s.Video[1*LowResWidth+1] != 1 {
+		t.Error("sprite at 65,33 not drawn at 1,1")
+	}
+}
+
+func TestClear(t *testing.T) {
+	s, _ := run(t, 2, 0xF029, 0xD005)
+	s.Memory[s.PC], s.Memory[s.PC+1] = 0x00, 0xE0
+	step(t, s, 1)
+	for _, p := range s.Video {
+		if p != 0 {
+			t.Fatal("display not cleared")
+		}
+	}
+}
+
+func TestHighRes(t *testing.T) {
+	// high, then draw the 16x16 sprite of ones at $20A at 120,0.
+	s, m := run(t, 5, 0x00FF, 0x6078, 0x6100, 0xA20A, 0xD010)
+	if !s.HighRes || m.width != HighResWidth {
+		t.Fatalf("high: HighRes %v, width %d", s.HighRes, m.width)
+	}
+	if s.SpriteWidth != 16 || s.SpriteSize != 32 {
+		t.Errorf("sprite %d wide, %d bytes, want 16, 32", s.SpriteWidth, s.SpriteSize)
+	}
+	if s.Video[120] != 0 || s.Video[127] != 0 {
+		t.Errorf("empty sprite drew pixels")
+	}
+
+	// Draw a sprite of ones: 8 of the 16 columns are clipped.
+	s.PC = 0x208
+	for i := 0; i < 32; i++ {
+		s.Memory[0x20A+i] = 0xFF
+	}
+	s.Memory[0x208], s.Memory[0x209] = 0xD0, 0x10
+	step(t, s, 1)
+	lit := 0
+	for _, p := range s.Video {
+		lit += int(p)
+	}
+	if lit != 8*16 {
+		t.Errorf("%d pixels lit, want %d", lit, 8*16)
+	}
+
+	s.Memory[0x20A], s.Memory[0x20B] = 0x00, 0xFE
+	s.PC = 0x20A
+	step(t, s, 1)
+	if s.HighRes || m.width != LowResWidth || s.Video[120] != 0 {
+		t.Errorf("low: HighRes %v, width %d", s.HighRes, m.width)
+	}
+}
+
+func TestDrawZeroLowRes(t *testing.T) {
+	// draw with height 0 draws nothing in low resolution.
+	s, _ := run(t, 2, 0xA000, 0xD000)
+	for _, p := range s.Video {
+		if p != 0 {
+			t.Fatal("draw vX vY 0 drew in low resolution")
+		}
+	}
+}
+
+func TestScroll(t *testing.T) {
+	// A pixel at 10,10, scrolled down 3, right 4 and left 4 twice.
+	s, _ := run(t, 4, 0x600A, 0xA208, 0xD001, 0x00C3, 0x8000)
+	if s.Video[13*LowResWidth+10] != 1 {
+		t.Fatalf("scr 3 did not move the pixel to 10,13")
+	}
+
+	scroll := func(op uint16, x int) {
+		t.Helper()
+		s.PC = 0x208
+		s.Memory[0x208], s.Memory[0x209] = byte(op>>8), byte(op)
+		step(t, s, 1)
+		if s.Video[13*LowResWidth+x] != 1 {
+			t.Fatalf("$%04X did not move the pixel to %d,13", op, x)
+		}
+	}
+	scroll(0x00FB, 14)
+	scroll(0x00FC, 10)
+	scroll(0x00FC, 6)
+}
+
+func TestHalt(t *testing.T) {
+	s, _ := run(t, 3, 0x00FD, 0x6001)
+	if !s.Halted || s.PC != ProgramStart+2 || s.V[0] != 0 {
+		t.Errorf("Halted %v, PC = $%03X, v0 = %d", s.Halted, s.PC, s.V[0])
+	}
+}
+
+func TestSyscalls(t *testing.T) {
+	s, m := run(t, 2, 0x6032, 0x0100)
+	if m.freq != 500 {
+		t.Errorf("frequency %d, want 500", m.freq)
+	}
+
+	s, _ = run(t, 3, 0x601D, 0x61FF, 0x0102)
+	if s.BG != 29 || s.FG != 0xFF {
+		t.Errorf("colors %d %d, want 29 255", s.BG, s.FG)
+	}
+
+	s, _ = run(t, 2, 0x6001, 0x0101)
+	if s.V[0] != 0 || s.PC != ProgramStart {
+		t.Errorf("reset: v0 = %d, PC = $%03X", s.V[0], s.PC)
+	}
+
+	if err := NewSystem(newTestMachine(0x0123)).Step(); err == nil {
+		t.Error("unknown syscall did not fail")
+	}
+}
+
+func TestAudioPattern(t *testing.T) {
+	s, _ := run(t, 4, 0xA208, 0xF002, 0x6080, 0xF03A, 0x0102, 0x0304, 0x0506, 0x0708, 0x090A, 0x0B0C, 0x0D0E, 0x0F10)
+	if !s.HasPattern || s.Pattern[0] != 1 || s.Pattern[15] != 16 {
+		t.Errorf("pattern %v % X", s.HasPattern, s.Pattern)
+	}
+	if s.Pitch != 0x80 {
+		t.Errorf("pitch $%02X, want $80", s.Pitch)
+	}
+}
+
+func TestInvalidOpcodes(t *testing.T) {
+	for _, op := range []uint16{0x5121, 0x8128, 0x912F, 0xE19F, 0xF1FF, 0xF102} {
+		s := NewSystem(newTestMachine(op))
+		if err := s.Step(); err == nil {
+			t.Errorf("$%04X did not fail", op)
+		}
+	}
+}
+
+func TestAccess(t *testing.T) {
+	s := NewSystem(newTestMachine(0xA300, 0xF155, 0xF065))
+	var accesses []uint16
+	s.OnAccess = func(addr uint16, write bool) {
+		if write {
+			addr |= 0x8000
+		}
+		accesses = append(accesses, addr)
+	}
+	step(t, s, 3)
+
+	want := []uint16{0x8300, 0x8301, 0x300}
+	if len(accesses) != len(want) {
+		t.Fatalf("accesses % X, want % X", accesses, want)
+	}
+	for i := range want {
+		if accesses[i] != want[i] {
+			t.Fatalf("accesses % X, want % X", accesses, want)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	s, m := run(t, 0)
+	s.Refresh()
+	s.Refresh()
+	if m.frames != 1 || s.Invalid() {
+		t.Errorf("%d frames drawn, want 1", m.frames)
+	}
+
+	s.Invalidate()
+	s.Refresh()
+	if m.frames != 2 {
+		t.Errorf("%d frames drawn, want 2", m.frames)
+	}
+}
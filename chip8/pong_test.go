@@ -0,0 +1,147 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package chip8_test
+
+import (
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andreas-jonsson/chip8studio/assembler"
+	"github.com/andreas-jonsson/chip8studio/chip8"
+	"github.com/andreas-jonsson/chip8studio/example"
+)
+
+func init() {
+	assembler.Logger = log.New(ioutil.Discard, "", 0)
+}
+
+// pongMachine runs the example program.
+type pongMachine struct {
+	program []byte
+	keys    [16]bool
+	freq    int
+	tones   int
+}
+
+func (m *pongMachine) Load(memory []byte)       { copy(memory, m.program) }
+func (m *pongMachine) Rand() *rand.Rand         { return rand.New(rand.NewSource(1)) }
+func (m *pongMachine) BeginTone()               { m.tones++ }
+func (m *pongMachine) EndTone()                 {}
+func (m *pongMachine) Key(code int) bool        { return m.keys[code] }
+func (m *pongMachine) SetCPUFrequency(freq int) { m.freq = freq }
+func (m *pongMachine) ResizeVideo(width int)    {}
+func (m *pongMachine) Draw(video []byte)        {}
+
+func newPong(t *testing.T) (*chip8.System, *pongMachine) {
+	t.Helper()
+
+	fp, err := ioutil.TempFile("", "pong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	defer fp.Close()
+
+	_, diags := assembler.Assemble("pong.asm", strings.NewReader(example.Pong), fp, assembler.Options{})
+	if assembler.HasErrors(diags) {
+		t.Fatal(diags)
+	}
+
+	program, err := ioutil.ReadFile(fp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The program sets its own speed, with the first instructions.
+	m := &pongMachine{program: program, freq: 600}
+	return chip8.NewSystem(m), m
+}
+
+// runFrames runs frames frames at the speed the program asks for.
+func runFrames(t *testing.T, s *chip8.System, m *pongMachine, frames int) {
+	t.Helper()
+	for i := 0; i < frames; i++ {
+		for n := 0; n < m.freq/chip8.FrameRate; n++ {
+			if err := s.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.Tick()
+	}
+}
+
+// The example game is run as a whole through the core, the way the
+// emulator runs it.
+func TestPong(t *testing.T) {
+	s, m := newPong(t)
+	runFrames(t, s, m, 1)
+	if m.freq != 500 {
+		t.Errorf("got CPU frequency %d, want 500", m.freq)
+	}
+	if s.BG != 29 || s.FG != 255 {
+		t.Errorf("got colors %d, %d, want 29, 255", s.BG, s.FG)
+	}
+
+	// The ball is launched after $60 frames. Key 4 moves the left paddle
+	// down, two pixels for each turn of the game loop.
+	runFrames(t, s, m, 0x60+10)
+	if s.V[0xB] != 12 {
+		t.Fatalf("left paddle moved to %d", s.V[0xB])
+	}
+	m.keys[4] = true
+	for n := 0; s.V[0xB] == 12; n++ {
+		if n == 100 {
+			t.Fatal("left paddle did not move")
+		}
+		if err := s.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.V[0xB] != 14 {
+		t.Errorf("left paddle moved to %d, want 14", s.V[0xB])
+	}
+	m.keys[4] = false
+
+	// Sooner or later a player misses the ball.
+	for frame := 0; s.V[0xE] == 0; frame++ {
+		if frame == 60*60 {
+			t.Fatal("no point was scored")
+		}
+		runFrames(t, s, m, 1)
+	}
+	if s.V[0xE] != 1 && s.V[0xE] != 10 {
+		t.Errorf("got score %d, want 1 or 10", s.V[0xE])
+	}
+	if m.tones == 0 {
+		t.Error("the lost ball made no sound")
+	}
+}
+
+func TestPongDeterministic(t *testing.T) {
+	s1, m1 := newPong(t)
+	s2, m2 := newPong(t)
+	runFrames(t, s1, m1, 600)
+	runFrames(t, s2, m2, 600)
+	if s1.State != s2.State {
+		t.Error("two runs of the same program gave different states")
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package chip8
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Quirks select between the behaviors of different CHIP-8 implementations,
+// for instructions that programs disagree on. No quirks is the behavior of
+// Chippy.
+type Quirks uint32
+
+const (
+	// QuirkShiftVy makes shr and shl shift vY into vX. Without it vX is
+	// shifted in place.
+	QuirkShiftVy Quirks = 1 << iota
+
+	// QuirkLoadStoreI makes stor and read leave I after the last register
+	// stored or read. Without it I is left unchanged.
+	QuirkLoadStoreI
+
+	// QuirkLoadStoreX makes stor and read leave I at the last register
+	// stored or read, one less than QuirkLoadStoreI, as on CHIP-48.
+	QuirkLoadStoreX
+
+	// QuirkJumpVX makes jump0 $xnn jump to $xnn plus vX instead of v0.
+	QuirkJumpVX
+
+	// QuirkWrap makes sprites wrap around the edges of the display instead
+	// of being clipped.
+	QuirkWrap
+
+	// QuirkVFReset makes or, and and xor clear vF.
+	QuirkVFReset
+
+	// QuirkVBlank makes draw wait for the next frame before the program
+	// continues, as the COSMAC VIP waits for the display to be drawn.
+	QuirkVBlank
+)
+
+// AllQuirks lists every quirk, in the order of their bits.
+var AllQuirks = []Quirks{QuirkShiftVy, QuirkLoadStoreI, QuirkLoadStoreX, QuirkJumpVX, QuirkWrap, QuirkVFReset, QuirkVBlank}
+
+var quirkNames = []string{
+	"shift-vy",
+	"loadstore-i",
+	"loadstore-x",
+	"jump-vx",
+	"wrap",
+	"vf-reset",
+	"vblank",
+}
+
+// Profile is the quirks of a CHIP-8 implementation.
+type Profile struct {
+	Name   string
+	Quirks Quirks
+}
+
+// Profiles are the known implementations, Chippy first.
+var Profiles = []Profile{
+	{"Chippy", 0},
+	{"COSMAC VIP", QuirkShiftVy | QuirkLoadStoreI | QuirkVFReset | QuirkVBlank},
+	{"CHIP-48", QuirkLoadStoreX | QuirkJumpVX},
+	{"SuperChip 1.1", QuirkJumpVX},
+	{"XO-CHIP", QuirkShiftVy | QuirkLoadStoreI | QuirkWrap},
+}
+
+// Has returns true if all the quirks of o are set.
+func (q Quirks) Has(o Quirks) bool {
+	return q&o == o
+}
+
+// Name returns the name of a single quirk.
+func (q Quirks) Name() string {
+	for i, quirk := range AllQuirks {
+		if q == quirk {
+			return quirkNames[i]
+		}
+	}
+	return fmt.Sprintf("$%X", uint32(q))
+}
+
+// String returns the name of the first profile with the quirks, or the
+// names of the quirks separated by commas.
+func (q Quirks) String() string {
+	for _, p := range Profiles {
+		if p.Quirks == q {
+			return p.Name
+		}
+	}
+
+	var names []string
+	for i := uint(0); i < 32; i++ {
+		if bit := Quirks(1) << i; q.Has(bit) {
+			names = append(names, bit.Name())
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseQuirks parses a profile name, or a comma separated list of quirk
+// names, in the format written by String. Case and spaces do not matter.
+// An empty string has no quirks, as the Chippy profile.
+func ParseQuirks(s string) (Quirks, error) {
+	if strings.TrimSpace(s) == "" {
+		return 0, nil
+	}
+
+	simplify := func(s string) string {
+		return strings.ToLower(strings.Replace(s, " ", "", -1))
+	}
+
+	s = simplify(s)
+	for _, p := range Profiles {
+		if simplify(p.Name) == s {
+			return p.Quirks, nil
+		}
+	}
+
+	var q Quirks
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for i, n := range quirkNames {
+			if n == name {
+				q |= AllQuirks[i]
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown quirk or profile %q", name)
+		}
+	}
+	return q, nil
+}
+
+// LoadQuirks reads quirks from a file, like the one the studio keeps next
+// to a project. A file that does not exist has no quirks.
+func LoadQuirks(fileName string) (Quirks, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	q, err := ParseQuirks(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return q, nil
+}
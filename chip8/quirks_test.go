@@ -0,0 +1,246 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package chip8
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runQuirks creates a system with quirks for the opcodes and executes n
+// instructions.
+func runQuirks(t *testing.T, q Quirks, n int, opcodes ...uint16) *System {
+	t.Helper()
+	s := NewSystem(newTestMachine(opcodes...))
+	s.Quirks = q
+	step(t, s, n)
+	return s
+}
+
+func TestQuirkShiftVy(t *testing.T) {
+	tests := []struct {
+		name   string
+		quirks Quirks
+		op     uint16
+		v0, vF byte
+	}{
+		{"shr", 0, 0x8016, 0x01, 0},
+		{"shr shift-vy", QuirkShiftVy, 0x8016, 0x40, 1},
+		{"shl", 0, 0x801E, 0x04, 0},
+		{"shl shift-vy", QuirkShiftVy, 0x801E, 0x02, 1},
+	}
+
+	for _, test := range tests {
+		// v0 = 2, v1 = $81
+		s := runQuirks(t, test.quirks, 3, 0x6002, 0x6181, test.op)
+		if s.V[0] != test.v0 || s.V[0xF] != test.vF {
+			t.Errorf("%s: v0 = $%02X, vF = %d, want $%02X, %d", test.name, s.V[0], s.V[0xF], test.v0, test.vF)
+		}
+		if s.V[1] != 0x81 {
+			t.Errorf("%s: v1 changed to $%02X", test.name, s.V[1])
+		}
+	}
+}
+
+func TestQuirkLoadStore(t *testing.T) {
+	tests := []struct {
+		quirks Quirks
+		i      uint16
+	}{
+		{0, 0x300},
+		{QuirkLoadStoreI, 0x303},
+		{QuirkLoadStoreX, 0x302},
+	}
+
+	for _, test := range tests {
+		// stor v2 at $300, then read v2 from $310.
+		s := runQuirks(t, test.quirks, 5, 0x6011, 0x6122, 0x6233, 0xA300, 0xF255)
+		if s.I != test.i {
+			t.Errorf("%v: stor v2 left I = $%03X, want $%03X", test.quirks, s.I, test.i)
+		}
+		if got := s.Memory[0x300:0x303]; got[0] != 0x11 || got[1] != 0x22 || got[2] != 0x33 {
+			t.Errorf("%v: stor v2 wrote % X", test.quirks, got)
+		}
+
+		s = runQuirks(t, test.quirks, 2, 0xA310, 0xF265)
+		if s.I != test.i+0x10 {
+			t.Errorf("%v: read v2 left I = $%03X, want $%03X", test.quirks, s.I, test.i+0x10)
+		}
+	}
+}
+
+func TestQuirkJumpVX(t *testing.T) {
+	// v0 = 4, v1 = 8, jump0 $100
+	s := runQuirks(t, 0, 3, 0x6004, 0x6108, 0xB100)
+	if s.PC != 0x104 {
+		t.Errorf("PC = $%03X, want $104", s.PC)
+	}
+
+	s = runQuirks(t, QuirkJumpVX, 3, 0x6004, 0x6108, 0xB100)
+	if s.PC != 0x108 {
+		t.Errorf("jump-vx: PC = $%03X, want $108", s.PC)
+	}
+}
+
+func TestQuirkWrap(t *testing.T) {
+	// Draw 3 rows of ones at 60,30: 4 columns and 2 rows are on the display.
+	program := []uint16{0x603C, 0x611E, 0xA208, 0xD013, 0xFFFF, 0xFF00}
+	lit := func(s *System) int {
+		n := 0
+		for _, p := range s.Video {
+			n += int(p)
+		}
+		return n
+	}
+
+	s := runQuirks(t, 0, 4, program...)
+	if n := lit(s); n != 4*2 || s.Video[0] != 0 {
+		t.Errorf("%d pixels lit, want 8 and none at 0,0", n)
+	}
+
+	s = runQuirks(t, QuirkWrap, 4, program...)
+	if n := lit(s); n != 8*3 {
+		t.Errorf("wrap: %d pixels lit, want 24", n)
+	}
+	if s.Video[0] != 1 || s.Video[31*LowResWidth+3] != 1 || s.Video[0*LowResWidth+60] != 1 {
+		t.Error("wrap: sprite not wrapped around the corners")
+	}
+}
+
+func TestQuirkVFReset(t *testing.T) {
+	for _, op := range []uint16{0x8011, 0x8012, 0x8013} {
+		// vF = 5, v0 = 3, v1 = 6
+		s := runQuirks(t, 0, 4, 0x6F05, 0x6003, 0x6106, op)
+		if s.V[0xF] != 5 {
+			t.Errorf("$%04X: vF = %d, want 5", op, s.V[0xF])
+		}
+
+		s = runQuirks(t, QuirkVFReset, 4, 0x6F05, 0x6003, 0x6106, op)
+		if s.V[0xF] != 0 {
+			t.Errorf("$%04X vf-reset: vF = %d, want 0", op, s.V[0xF])
+		}
+	}
+}
+
+func TestQuirkVBlank(t *testing.T) {
+	program := []uint16{0xA000, 0xD001, 0x6001}
+
+	s := runQuirks(t, 0, 3, program...)
+	if s.VBlankWait || s.V[0] != 1 {
+		t.Errorf("draw waited for the frame")
+	}
+
+	s = runQuirks(t, QuirkVBlank, 3, program...)
+	if !s.VBlankWait || s.V[0] != 0 || s.PC != 0x204 {
+		t.Fatalf("vblank: draw did not wait, PC = $%03X", s.PC)
+	}
+
+	s.Tick()
+	step(t, s, 1)
+	if s.VBlankWait || s.V[0] != 1 {
+		t.Errorf("vblank: program did not continue on the next frame")
+	}
+}
+
+func TestParseQuirks(t *testing.T) {
+	tests := []struct {
+		s      string
+		quirks Quirks
+	}{
+		{"Chippy", 0},
+		{"", 0},
+		{" \t\n", 0},
+		{"cosmac vip", QuirkShiftVy | QuirkLoadStoreI | QuirkVFReset | QuirkVBlank},
+		{"SUPERCHIP 1.1", QuirkJumpVX},
+		{"XO-CHIP", QuirkShiftVy | QuirkLoadStoreI | QuirkWrap},
+		{"wrap", QuirkWrap},
+		{"Wrap, VBlank", QuirkWrap | QuirkVBlank},
+		{"loadstore-x,jump-vx", QuirkLoadStoreX | QuirkJumpVX},
+	}
+
+	for _, test := range tests {
+		q, err := ParseQuirks(test.s)
+		if err != nil {
+			t.Errorf("%q: %v", test.s, err)
+		} else if q != test.quirks {
+			t.Errorf("%q = %v, want %v", test.s, q, test.quirks)
+		}
+	}
+
+	for _, s := range []string{"nope", ",", "wrap,nope", "wrap,,vblank"} {
+		if q, err := ParseQuirks(s); err == nil {
+			t.Errorf("%q parsed as %v", s, q)
+		}
+	}
+}
+
+func TestQuirksString(t *testing.T) {
+	if s := (QuirkLoadStoreX | QuirkJumpVX).String(); s != "CHIP-48" {
+		t.Errorf("got %q, want the CHIP-48 profile", s)
+	}
+	if s := (QuirkWrap | QuirkShiftVy).String(); s != "shift-vy,wrap" {
+		t.Errorf("got %q, want shift-vy,wrap", s)
+	}
+	if s := Quirks(1 << 10).String(); s != "$400" {
+		t.Errorf("unknown quirk is %q, want $400", s)
+	}
+
+	// Every combination of quirks parses back from its string.
+	for q := Quirks(0); q < 1<<uint(len(AllQuirks)); q++ {
+		if p, err := ParseQuirks(q.String()); err != nil || p != q {
+			t.Errorf("%q parsed as %v, %v, want %v", q.String(), p, err, q)
+		}
+	}
+}
+
+func TestLoadQuirks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quirks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "game.quirks")
+	if q, err := LoadQuirks(fileName); err != nil || q != 0 {
+		t.Errorf("missing file: %v, %v, want no quirks", q, err)
+	}
+
+	if err := ioutil.WriteFile(fileName, []byte(" \n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if q, err := LoadQuirks(fileName); err != nil || q != 0 {
+		t.Errorf("empty file: %v, %v, want no quirks", q, err)
+	}
+
+	if err := ioutil.WriteFile(fileName, []byte("COSMAC VIP\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if q, err := LoadQuirks(fileName); err != nil || q != Profiles[1].Quirks {
+		t.Errorf("got %v, %v, want %v", q, err, Profiles[1].Quirks)
+	}
+
+	if err := ioutil.WriteFile(fileName, []byte("wrap,nope\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadQuirks(fileName); err == nil || !strings.HasPrefix(err.Error(), fileName) {
+		t.Errorf("invalid file: %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package chip8
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StateVersion is the version of the save state format written by SaveState.
+//
+// A save state is a header followed by the machine state, with every number
+// stored big-endian:
+//
+//	Offset  Size  Field
+//	     0     4  Magic, "C8ST"
+//	     4     2  Version
+//	     6     2  Flags: bit 0 high resolution, bit 1 halted,
+//	              bit 2 audio pattern loaded, bit 3 waiting for
+//	              the next frame
+//	     8    20  SHA-1 of the program the state was saved with
+//	    28     4  Quirks the state was saved with, see Quirks
+//	    32    16  V0-VF
+//	    48     2  I
+//	    50     2  PC
+//	    52     1  SP
+//	    53     1  Delay timer
+//	    54     1  Sound timer
+//	    55     1  Background color
+//	    56     1  Foreground color
+//	    57     8  RPL user flags
+//	    65    32  Stack, 16 addresses
+//	    97     8  Frames since reset
+//	   105  4096  Memory
+//	  4201  1024  Display, one bit per pixel, most significant bit first,
+//	              in rows of 128 pixels in high resolution and 64 in low
+//	  5225    16  Audio pattern (version 2)
+//	  5241     1  Pitch register (version 2)
+//	  5242     4  Speed set by the program, in instructions per
+//	              second, or 0 if it has not set one (version 3)
+//
+// The size of a version 3 state is 5246 bytes. Version 1 and 2 states,
+// without the fields added later, can still be loaded.
+const StateVersion = 3
+
+var stateMagic = [4]byte{'C', '8', 'S', 'T'}
+
+const (
+	stateHighRes = 1 << iota
+	stateHalted
+	statePattern
+	stateVBlankWait
+)
+
+// ErrProgramMismatch is returned by LoadState for states saved with a
+// different program than the one loaded.
+var ErrProgramMismatch = errors.New("save state was made with a different program")
+
+type stateHeader struct {
+	Magic       [4]byte
+	Version     uint16
+	Flags       uint16
+	ProgramHash [sha1.Size]byte
+	Quirks      uint32
+}
+
+type stateData struct {
+	V      [16]byte
+	I      uint16
+	PC     uint16
+	SP     uint8
+	DT     uint8
+	ST     uint8
+	BG     uint8
+	FG     uint8
+	RPL    [8]byte
+	Stack  [StackSize]uint16
+	Frames uint64
+	Memory [MemorySize]byte
+	Video  [len(State{}.Video) / 8]byte
+}
+
+type stateAudio struct {
+	Pattern [16]byte
+	Pitch   uint8
+}
+
+type stateSpeed struct {
+	CPUSpeed uint32
+}
+
+// SaveState writes the state of the machine to w. The state can only be
+// loaded with the same program.
+func (s *System) SaveState(w io.Writer, program []byte) error {
+	header := stateHeader{
+		Magic:       stateMagic,
+		Version:     StateVersion,
+		ProgramHash: sha1.Sum(program),
+		Quirks:      uint32(s.Quirks),
+	}
+	if s.HighRes {
+		header.Flags |= stateHighRes
+	}
+	if s.Halted {
+		header.Flags |= stateHalted
+	}
+	if s.HasPattern {
+		header.Flags |= statePattern
+	}
+	if s.VBlankWait {
+		header.Flags |= stateVBlankWait
+	}
+
+	data := stateData{
+		V:      s.V,
+		I:      s.I,
+		PC:     s.PC,
+		SP:     uint8(s.SP),
+		DT:     s.DT,
+		ST:     s.ST,
+		BG:     s.BG,
+		FG:     s.FG,
+		RPL:    s.RPL,
+		Stack:  s.Stack,
+		Frames: s.Frames,
+		Memory: s.Memory,
+	}
+	for i, p := range s.Video {
+		data.Video[i/8] |= p << uint(7-i%8)
+	}
+
+	if err := binary.Write(w, binary.BigEndian, &header); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, &data); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, &stateAudio{s.Pattern, s.Pitch}); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, &stateSpeed{uint32(s.CPUSpeed)})
+}
+
+// LoadState reads a state written by SaveState, including the quirks it
+// was saved with. It returns ErrProgramMismatch, leaving the machine as it
+// was, if the state was saved with a different program.
+func (s *System) LoadState(r io.Reader, program []byte) error {
+	var header stateHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return err
+	}
+
+	if header.Magic != stateMagic {
+		return errors.New("not a save state")
+	}
+	if header.Version < 1 || header.Version > StateVersion {
+		return fmt.Errorf("unsupported save state version %d", header.Version)
+	}
+	if header.ProgramHash != sha1.Sum(program) {
+		return ErrProgramMismatch
+	}
+
+	var data stateData
+	if err := binary.Read(r, binary.BigEndian, &data); err != nil {
+		return err
+	}
+	if int(data.SP) > StackSize {
+		return fmt.Errorf("invalid stack pointer %d in save state", data.SP)
+	}
+
+	audio := stateAudio{Pitch: defaultPitch}
+	if header.Version >= 2 {
+		if err := binary.Read(r, binary.BigEndian, &audio); err != nil {
+			return err
+		}
+	}
+
+	var speed stateSpeed
+	if header.Version >= 3 {
+		if err := binary.Read(r, binary.BigEndian, &speed); err != nil {
+			return err
+		}
+	}
+
+	st := State{
+		Registers: Registers{
+			V:     data.V,
+			I:     data.I,
+			PC:    data.PC,
+			Stack: data.Stack,
+			SP:    int(data.SP),
+			DT:    data.DT,
+			ST:    data.ST,
+			RPL:   data.RPL,
+		},
+		Memory:  data.Memory,
+		Frames:  data.Frames,
+		HighRes: header.Flags&stateHighRes != 0,
+		Halted:  header.Flags&stateHalted != 0,
+		BG:      data.BG,
+		FG:      data.FG,
+
+		VBlankWait: header.Flags&stateVBlankWait != 0,
+		Pattern:    audio.Pattern,
+		HasPattern: header.Flags&statePattern != 0,
+		Pitch:      audio.Pitch,
+		CPUSpeed:   int(speed.CPUSpeed),
+	}
+	for i := range st.Video {
+		st.Video[i] = data.Video[i/8] >> uint(7-i%8) & 1
+	}
+
+	s.SetState(st)
+	s.Quirks = Quirks(header.Quirks)
+	return nil
+}
@@ -0,0 +1,193 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+const stateSize = 5246
+
+// stateProgram sets the speed, switches to high resolution, draws, calls
+// a subroutine, and sets the timers, the user flags and the audio pattern.
+var stateProgram = []uint16{
+	0x00FF, // $200 high
+	0x6005, // $202 load v0 5
+	0xF030, // $204 ldhspr v0
+	0xD010, // $206 draw v0 v1 0
+	0x6A7B, // $208 load vA $7B
+	0xFA15, // $20A loadd vA
+	0xF018, // $20C loads v0
+	0xFA75, // $20E storr vA
+	0xA000, // $210 loadi $000
+	0xF002, // $212 audio
+	0xFA3A, // $214 pitch vA
+	0x2218, // $216 call $218
+	0x6101, // $218 load v1 1
+	0x6032, // $21A load v0 50
+	0x0100, // $21C sys $100
+}
+
+func stateSystem(t *testing.T) (*System, []byte) {
+	t.Helper()
+	m := newTestMachine(stateProgram...)
+	s := NewSystem(m)
+	s.Quirks = QuirkJumpVX | QuirkWrap
+	step(t, s, len(stateProgram))
+	s.Tick()
+	s.Tick()
+	return s, m.program
+}
+
+func saveState(t *testing.T, s *System, program []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := s.SaveState(&buf, program); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSaveLoadState(t *testing.T) {
+	s, program := stateSystem(t)
+	data := saveState(t, s, program)
+	if len(data) != stateSize {
+		t.Fatalf("state is %d bytes, want %d", len(data), stateSize)
+	}
+
+	m := newTestMachine(stateProgram...)
+	loaded := NewSystem(m)
+	if err := loaded.LoadState(bytes.NewReader(data), program); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.State != s.State {
+		t.Errorf("loaded state differs\ngot  %+v\nwant %+v", loaded.Registers, s.Registers)
+	}
+	if loaded.Quirks != s.Quirks {
+		t.Errorf("quirks %v, want %v", loaded.Quirks, s.Quirks)
+	}
+	if m.width != HighResWidth || !m.tone || m.freq != 500 {
+		t.Errorf("machine width %d, tone %v, speed %d, want %d, true, 500", m.width, m.tone, m.freq, HighResWidth)
+	}
+
+	// Make sure the program got the machine into the state it should.
+	if !loaded.HighRes || loaded.DT != 0x79 || loaded.ST != 3 || loaded.SP != 1 || loaded.RPL[0] != 5 || !loaded.HasPattern || loaded.Pitch != 0x7B || loaded.Frames != 2 || loaded.CPUSpeed != 500 {
+		t.Errorf("unexpected state %+v", loaded.Registers)
+	}
+}
+
+func TestLoadStateMismatch(t *testing.T) {
+	s, program := stateSystem(t)
+	data := saveState(t, s, program)
+
+	other := NewSystem(newTestMachine(0x1200))
+	before := other.State
+	if err := other.LoadState(bytes.NewReader(data), []byte{0x12, 0x00}); err != ErrProgramMismatch {
+		t.Fatalf("got %v, want ErrProgramMismatch", err)
+	}
+	if other.State != before || other.Quirks != 0 {
+		t.Error("state changed by a failed load")
+	}
+}
+
+func TestLoadStateVersion1(t *testing.T) {
+	s, program := stateSystem(t)
+	data := saveState(t, s, program)
+
+	// A version 1 state ends after the display, and has no audio flag.
+	v1 := append([]byte{}, data[:stateSize-21]...)
+	binary.BigEndian.PutUint16(v1[4:], 1)
+	flags := binary.BigEndian.Uint16(v1[6:])
+	binary.BigEndian.PutUint16(v1[6:], flags&^statePattern)
+
+	loaded := NewSystem(newTestMachine(stateProgram...))
+	if err := loaded.LoadState(bytes.NewReader(v1), program); err != nil {
+		t.Fatal(err)
+	}
+
+	want := s.State
+	want.Pattern, want.HasPattern, want.Pitch, want.CPUSpeed = [16]byte{}, false, defaultPitch, 0
+	if loaded.State != want {
+		t.Errorf("loaded state differs\ngot  %+v\nwant %+v", loaded.Registers, want.Registers)
+	}
+}
+
+func TestLoadStateVersion2(t *testing.T) {
+	s, program := stateSystem(t)
+	data := saveState(t, s, program)
+
+	// A version 2 state ends after the pitch register, and leaves the
+	// machine at the speed it runs at.
+	v2 := append([]byte{}, data[:stateSize-4]...)
+	binary.BigEndian.PutUint16(v2[4:], 2)
+
+	m := newTestMachine(stateProgram...)
+	m.freq = 1000
+	loaded := NewSystem(m)
+	if err := loaded.LoadState(bytes.NewReader(v2), program); err != nil {
+		t.Fatal(err)
+	}
+
+	want := s.State
+	want.CPUSpeed = 0
+	if loaded.State != want {
+		t.Errorf("loaded state differs\ngot  %+v\nwant %+v", loaded.Registers, want.Registers)
+	}
+	if m.freq != 1000 {
+		t.Errorf("machine speed %d, want 1000", m.freq)
+	}
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	s, program := stateSystem(t)
+	data := saveState(t, s, program)
+
+	corrupt := func(f func(b []byte)) []byte {
+		b := append([]byte{}, data...)
+		f(b)
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"magic", corrupt(func(b []byte) { b[0] = 'X' })},
+		{"version 0", corrupt(func(b []byte) { binary.BigEndian.PutUint16(b[4:], 0) })},
+		{"future version", corrupt(func(b []byte) { binary.BigEndian.PutUint16(b[4:], StateVersion+1) })},
+		{"stack pointer", corrupt(func(b []byte) { b[52] = StackSize + 1 })},
+		{"truncated", data[:1000]},
+		{"no audio", data[:stateSize-21]},
+		{"no speed", data[:stateSize-4]},
+	}
+
+	for _, test := range tests {
+		loaded := NewSystem(newTestMachine(stateProgram...))
+		before := loaded.State
+		if err := loaded.LoadState(bytes.NewReader(test.data), program); err == nil {
+			t.Errorf("%s: loaded without error", test.name)
+		}
+		if loaded.State != before {
+			t.Errorf("%s: state changed by a failed load", test.name)
+		}
+	}
+}
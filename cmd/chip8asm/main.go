@@ -0,0 +1,141 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreas-jonsson/chip8studio/assembler"
+)
+
+var (
+	outputFile  string
+	listingFile string
+	symbolFile  string
+	verbose     bool
+
+	warningsAsErrors bool
+)
+
+func init() {
+	flag.StringVar(&outputFile, "o", "", "output binary, defaults to the first source file with a .ch8 extension")
+	flag.StringVar(&listingFile, "l", "", "write a listing file")
+	flag.StringVar(&symbolFile, "s", "", "write a symbol file")
+	flag.BoolVar(&verbose, "v", false, "print the program size")
+	flag.BoolVar(&warningsAsErrors, "Werror", false, "treat warnings as errors")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] source.asm...\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	sources := flag.Args()
+	if outputFile == "" {
+		outputFile = strings.TrimSuffix(sources[0], filepath.Ext(sources[0])) + ".ch8"
+	}
+
+	assembler.Logger = log.New(ioutil.Discard, "", 0)
+	if verbose {
+		assembler.Logger = log.New(os.Stderr, "", 0)
+	}
+
+	if err := assemble(sources); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// assemble writes the program to a temporary file next to the output, and
+// only replaces the output once the program assembled without errors, so
+// a failed build leaves the last good binary in place.
+func assemble(sources []string) error {
+	fp, err := ioutil.TempFile(filepath.Dir(outputFile), filepath.Base(outputFile))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fp.Name())
+
+	prog, diags := assembler.AssembleFiles(sources, fp, assembler.Options{WarningsAsErrors: warningsAsErrors})
+	if err := fp.Close(); err != nil {
+		return err
+	}
+
+	for _, d := range diags {
+		fmt.Fprintln(os.Stderr, d)
+	}
+
+	if assembler.HasErrors(diags) {
+		return fmt.Errorf("%d error(s)", assembler.CountErrors(diags))
+	}
+
+	if err := os.Chmod(fp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(fp.Name(), outputFile); err != nil {
+		return err
+	}
+
+	if listingFile == "" && symbolFile == "" {
+		return nil
+	}
+
+	binary, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		return err
+	}
+
+	if listingFile != "" {
+		if err := writeFile(listingFile, func(w io.Writer) error { return prog.WriteListing(w, binary) }); err != nil {
+			return err
+		}
+	}
+	if symbolFile != "" {
+		if err := writeFile(symbolFile, prog.WriteSymbols); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(fileName string, write func(io.Writer) error) error {
+	fp, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	if err := write(fp); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
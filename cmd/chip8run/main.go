@@ -0,0 +1,292 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/andreas-jonsson/chip8studio/assembler"
+	"github.com/andreas-jonsson/chip8studio/audio"
+	"github.com/andreas-jonsson/chip8studio/chip8"
+	"github.com/andreas-jonsson/chip8studio/emulator"
+	"github.com/andreas-jonsson/chip8studio/emulator/headless"
+)
+
+var (
+	frames    uint64
+	cycles    int
+	speed     int
+	perFrame  int
+	seed      int64
+	keyScript string
+	quirks    string
+	pngFile   string
+	jsonFile  string
+	wavFile   string
+)
+
+func init() {
+	flag.Uint64Var(&frames, "frames", 600, "number of 60 Hz frames to run, 0 for no limit")
+	flag.IntVar(&cycles, "cycles", 0, "number of instructions to run, 0 for no limit")
+	flag.IntVar(&speed, "speed", emulator.DefaultCPUSpeed, "instructions per second")
+	flag.IntVar(&perFrame, "cpf", 0, "instructions per frame, defaults to the speed divided by 60")
+	flag.Int64Var(&seed, "seed", 0, "seed of the random number generator")
+	flag.StringVar(&keyScript, "keys", "", "keys to press, as frame:keys pairs like \"60:5 90: 120:4C\"")
+	flag.StringVar(&quirks, "quirks", "", "quirks profile, or comma separated quirks, defaults to the contents of program.quirks or Chippy")
+	flag.StringVar(&pngFile, "png", "", "write the final display to a PNG file")
+	flag.StringVar(&jsonFile, "json", "", "write the final registers to a JSON file, - for standard output")
+	flag.StringVar(&wavFile, "wav", "", "write the sound to a WAV file")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] program.ch8|program.asm\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if frames == 0 && cycles == 0 {
+		fmt.Fprintln(os.Stderr, "-frames and -cycles can not both be 0")
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// keyEvent sets the pressed keys from a frame on.
+type keyEvent struct {
+	frame uint64
+	keys  [16]bool
+}
+
+// parseKeys parses a key script: space separated frame:keys pairs, where
+// keys are the hex digits of the keys held from that frame on.
+func parseKeys(script string) ([]keyEvent, error) {
+	var events []keyEvent
+	for _, field := range strings.Fields(script) {
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid key event %q, expected frame:keys", field)
+		}
+
+		frame, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid frame in key event %q", field)
+		}
+		if len(events) > 0 && frame < events[len(events)-1].frame {
+			return nil, fmt.Errorf("key event %q is out of order", field)
+		}
+
+		ev := keyEvent{frame: frame}
+		for _, c := range parts[1] {
+			k, err := strconv.ParseUint(string(c), 16, 4)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key %q in key event %q", c, field)
+			}
+			ev.keys[k] = true
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+func load(fileName string) ([]byte, error) {
+	if strings.ToLower(filepath.Ext(fileName)) != ".asm" {
+		return ioutil.ReadFile(fileName)
+	}
+
+	fp, err := ioutil.TempFile("", "chip8run")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(fp.Name())
+
+	assembler.Logger = log.New(ioutil.Discard, "", 0)
+	_, diags := assembler.AssembleFiles([]string{fileName}, fp, assembler.Options{})
+	fp.Close()
+
+	for _, d := range diags {
+		fmt.Fprintln(os.Stderr, d)
+	}
+	if assembler.HasErrors(diags) {
+		return nil, fmt.Errorf("%d error(s)", assembler.CountErrors(diags))
+	}
+	return ioutil.ReadFile(fp.Name())
+}
+
+// loadQuirks returns the quirks given by -quirks, or else those saved next
+// to the program.
+func loadQuirks(fileName string) (chip8.Quirks, error) {
+	if quirks != "" {
+		return chip8.ParseQuirks(quirks)
+	}
+
+	return chip8.LoadQuirks(strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".quirks")
+}
+
+func run(fileName string) error {
+	events, err := parseKeys(keyScript)
+	if err != nil {
+		return err
+	}
+
+	program, err := load(fileName)
+	if err != nil {
+		return err
+	}
+	if len(program) > chip8.MemorySize-chip8.ProgramStart {
+		return fmt.Errorf("%s: program is too large, %d bytes", fileName, len(program))
+	}
+
+	q, err := loadQuirks(fileName)
+	if err != nil {
+		return err
+	}
+
+	m := headless.New(program)
+	m.Seed, m.CPUFrequency = seed, speed
+	if wavFile != "" {
+		fp, err := os.Create(wavFile)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+
+		if m.Sink, err = audio.NewWAV(fp, m.Tone.SampleRate); err != nil {
+			return err
+		}
+	}
+	sys := chip8.NewSystem(m)
+	sys.Quirks = q
+
+	sched := &emulator.Scheduler{
+		System: sys,
+		Speed: func() int {
+			return m.CPUFrequency
+		},
+		CyclesPerFrame: perFrame,
+	}
+
+	n, runErr := m.Run(sched, frames, cycles, func(frame uint64) {
+		for len(events) > 0 && events[0].frame <= frame {
+			m.Keys = events[0].keys
+			events = events[1:]
+		}
+	})
+
+	if err := m.Sink.Close(); err != nil {
+		return err
+	}
+
+	if pngFile != "" {
+		if err := writeFile(pngFile, func(w io.Writer) error { return png.Encode(w, m.Image()) }); err != nil {
+			return err
+		}
+	}
+	if jsonFile != "" {
+		if err := writeFile(jsonFile, func(w io.Writer) error { return writeRegisters(w, sys, n) }); err != nil {
+			return err
+		}
+	}
+	return runErr
+}
+
+// registers is the JSON form of the machine state. Byte arrays are lists of
+// numbers, where encoding/json would write byte slices as base64.
+type registers struct {
+	V       []int  `json:"v"`
+	I       uint16 `json:"i"`
+	PC      uint16 `json:"pc"`
+	SP      int    `json:"sp"`
+	Stack   []int  `json:"stack"`
+	DT      byte   `json:"dt"`
+	ST      byte   `json:"st"`
+	RPL     []int  `json:"rpl"`
+	HighRes bool   `json:"highres"`
+	Quirks  string `json:"quirks"`
+	Halted  bool   `json:"halted"`
+	Frames  uint64 `json:"frames"`
+	Cycles  int    `json:"cycles"`
+}
+
+func ints(b []byte) []int {
+	s := make([]int, len(b))
+	for i, v := range b {
+		s[i] = int(v)
+	}
+	return s
+}
+
+func writeRegisters(w io.Writer, sys *chip8.System, cycles int) error {
+	regs := registers{
+		V:       ints(sys.V[:]),
+		I:       sys.I,
+		PC:      sys.PC,
+		SP:      sys.SP,
+		DT:      sys.DT,
+		ST:      sys.ST,
+		RPL:     ints(sys.RPL[:]),
+		HighRes: sys.HighRes,
+		Quirks:  sys.Quirks.String(),
+		Halted:  sys.Halted,
+		Frames:  sys.Frames,
+		Cycles:  cycles,
+		Stack:   make([]int, 0, sys.SP),
+	}
+	for _, addr := range sys.Stack[:sys.SP] {
+		regs.Stack = append(regs.Stack, int(addr))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&regs)
+}
+
+func writeFile(fileName string, write func(io.Writer) error) error {
+	if fileName == "-" {
+		return write(os.Stdout)
+	}
+
+	fp, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	if err := write(fp); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/andreas-jonsson/chip8studio/example"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestParseKeys(t *testing.T) {
+	events, err := parseKeys(" 0:1  60:4C 60: 90:f ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want [4]keyEvent
+	want[0].frame, want[0].keys[1] = 0, true
+	want[1].frame, want[1].keys[4], want[1].keys[0xC] = 60, true, true
+	want[2].frame = 60
+	want[3].frame, want[3].keys[0xF] = 90, true
+	if !reflect.DeepEqual(events, want[:]) {
+		t.Errorf("got %v, want %v", events, want)
+	}
+
+	if events, err := parseKeys(""); err != nil || len(events) != 0 {
+		t.Errorf("empty script gave %v, %v", events, err)
+	}
+}
+
+func TestParseKeysErrors(t *testing.T) {
+	for _, script := range []string{
+		"60",
+		"60 90:1",
+		":1",
+		"x:1",
+		"-1:1",
+		"60:G",
+		"60:1-",
+		"90:1 60:2",
+	} {
+		if _, err := parseKeys(script); err == nil {
+			t.Errorf("%q parsed without error", script)
+		}
+	}
+}
+
+// TestPong runs the example game, with the left player moving, and checks
+// the final registers and display against testdata. Run the test with
+// -update to write them.
+func TestPong(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chip8run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "pong.asm")
+	if err := ioutil.WriteFile(source, []byte(example.Pong), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(f uint64, k, j, p string) {
+		frames, keyScript, jsonFile, pngFile = f, k, j, p
+	}(frames, keyScript, jsonFile, pngFile)
+	frames, keyScript = 300, "100:4 130: 200:1 210:"
+	jsonFile, pngFile = filepath.Join(dir, "pong.json"), filepath.Join(dir, "pong.png")
+
+	if err := run(source); err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		for _, name := range []string{jsonFile, pngFile} {
+			data, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join("testdata", filepath.Base(name)), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	got, err := ioutil.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile(filepath.Join("testdata", "pong.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("registers differ\ngot\n%s\nwant\n%s", got, want)
+	}
+
+	// The images are compared by pixels, which do not depend on how the
+	// encoder compresses them.
+	gotImage, wantImage := readPNG(t, pngFile), readPNG(t, filepath.Join("testdata", "pong.png"))
+	if gotImage.Bounds() != wantImage.Bounds() {
+		t.Fatalf("display is %v, want %v", gotImage.Bounds(), wantImage.Bounds())
+	}
+	b := wantImage.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if gotImage.At(x, y) != wantImage.At(x, y) {
+				t.Fatalf("display differs at %d,%d", x, y)
+			}
+		}
+	}
+}
+
+func readPNG(t *testing.T, fileName string) image.Image {
+	t.Helper()
+	fp, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	img, err := png.Decode(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
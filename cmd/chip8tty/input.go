@@ -0,0 +1,178 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"sync/atomic"
+	"unicode/utf8"
+
+	"golang.org/x/mobile/event/key"
+)
+
+// Controls are control keys, as letters and digits are taken by the keypad.
+const (
+	ctrlB = 0x02 // Build
+	ctrlC = 0x03 // Quit
+	ctrlN = 0x0E // Step
+	ctrlO = 0x0F // Step over
+	ctrlP = 0x10 // Pause
+	ctrlQ = 0x11 // Quit
+	ctrlR = 0x12 // Run
+	ctrlT = 0x14 // Turbo
+	ctrlU = 0x15 // Step out
+	ctrlX = 0x18 // Reset
+	esc   = 0x1B
+)
+
+// keyCode returns the keyboard key of a character typed in the terminal.
+func keyCode(r rune) (key.Code, bool) {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return key.CodeA + key.Code(r-'a'), true
+	case r >= 'A' && r <= 'Z':
+		return key.CodeA + key.Code(r-'A'), true
+	case r >= '1' && r <= '9':
+		return key.Code1 + key.Code(r-'1'), true
+	case r == '0':
+		return key.Code0, true
+	}
+	return 0, false
+}
+
+// input is a control key, or a key for the keypad if control is zero.
+type input struct {
+	control byte
+	key     key.Event
+}
+
+// decodeInput splits what was read from the terminal into control keys and
+// keys for the keypad. Escape sequences, like those of the arrow and
+// function keys and of characters typed with Alt, and characters without a
+// key are skipped.
+func decodeInput(data []byte) []input {
+	var inputs []input
+	for len(data) > 0 {
+		switch c := data[0]; {
+		case c == esc:
+			n := 1
+			switch {
+			case len(data) < 2 || data[1] < 0x20:
+			case data[1] == '[':
+				// Arrows, function keys and others, ended by a
+				// byte in $40-$7E.
+				for n = 2; n < len(data) && (data[n] < 0x40 || data[n] > 0x7E); n++ {
+				}
+				n++
+			case data[1] == 'O':
+				// Arrows and F1-F4 in application mode.
+				n = 3
+			default:
+				// A character typed with Alt.
+				n = 2
+			}
+			if n > len(data) {
+				n = len(data)
+			}
+			data = data[n:]
+		case c < 0x20:
+			inputs = append(inputs, input{control: c})
+			data = data[1:]
+		default:
+			r, n := utf8.DecodeRune(data)
+			if code, ok := keyCode(r); ok {
+				inputs = append(inputs, input{key: key.Event{Rune: r, Code: code, Direction: key.DirPress}})
+			}
+			data = data[n:]
+		}
+	}
+	return inputs
+}
+
+// resume lets the emulator continue past a breakpoint at PC.
+func resume() {
+	debug.Resume()
+	debug.Cancel()
+	message = ""
+}
+
+// runUntil runs the paused emulator until the debugger stops it where
+// until says, like the step commands of the studio.
+func runUntil(until func() bool) {
+	if atomic.LoadInt32(&emulatorPaused) <= 0 {
+		return
+	}
+	debug.Resume()
+	message = ""
+	if until() {
+		atomic.StoreInt32(&emulatorPaused, 0)
+	}
+}
+
+// handleInput handles what was read from the terminal. It is called with
+// the machine locked and returns true to quit.
+func handleInput(data []byte) bool {
+	for _, in := range decodeInput(data) {
+		switch in.control {
+		case 0:
+			system.Keypad.Event(in.key)
+		case ctrlC, ctrlQ:
+			return true
+		case ctrlR:
+			resume()
+			atomic.StoreInt32(&emulatorPaused, 0)
+		case ctrlP:
+			atomic.StoreInt32(&emulatorPaused, 1)
+		case ctrlN:
+			if atomic.LoadInt32(&emulatorPaused) > 0 {
+				resume()
+				atomic.StoreInt32(&emulatorPaused, -1)
+			}
+		case ctrlO:
+			runUntil(func() bool {
+				debug.StepOver()
+				return true
+			})
+		case ctrlU:
+			runUntil(func() bool {
+				if !debug.StepOut() {
+					message = "Not in a subroutine"
+					return false
+				}
+				return true
+			})
+		case ctrlX:
+			atomic.StoreInt32(&emulatorPaused, 1)
+			chippy.Reset()
+			scheduler.Reset()
+			debug.ClearHistory()
+			message = ""
+		case ctrlT:
+			if atomic.LoadInt32(&turbo) == 0 {
+				atomic.StoreInt32(&turbo, 1)
+				message = "Turbo on"
+			} else {
+				atomic.StoreInt32(&turbo, 0)
+				message = "Turbo off"
+			}
+		case ctrlB:
+			atomic.StoreInt32(&emulatorPaused, 1)
+			build()
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/mobile/event/key"
+
+	"github.com/andreas-jonsson/chip8studio/emulator"
+)
+
+func press(r rune, code key.Code) input {
+	return input{key: key.Event{Rune: r, Code: code, Direction: key.DirPress}}
+}
+
+func TestDecodeInput(t *testing.T) {
+	tests := []struct {
+		data string
+		want []input
+	}{
+		{"", nil},
+		{"\x12\x0e\x0f\x15\x11", []input{{control: ctrlR}, {control: ctrlN}, {control: ctrlO}, {control: ctrlU}, {control: ctrlQ}}},
+		{"aZ09", []input{press('a', key.CodeA), press('Z', key.CodeZ), press('0', key.Code0), press('9', key.Code9)}},
+		{"!é \x7f", nil},
+
+		// Arrow and function keys, Alt with a letter, and sequences cut
+		// off at the end of the read.
+		{"\x1b[Aq\x1b[15~w", []input{press('q', key.CodeQ), press('w', key.CodeW)}},
+		{"\x1b[1;5Cq", []input{press('q', key.CodeQ)}},
+		{"\x1bOAq\x1bOP", []input{press('q', key.CodeQ)}},
+		{"\x1bxq", []input{press('q', key.CodeQ)}},
+		{"q\x1b", []input{press('q', key.CodeQ)}},
+		{"q\x1b[1;5", []input{press('q', key.CodeQ)}},
+		{"\x1b\x12", []input{{control: ctrlR}}},
+	}
+
+	for _, tt := range tests {
+		if got := decodeInput([]byte(tt.data)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeInputKeypad(t *testing.T) {
+	keypad := emulator.Keypad{Map: emulator.DefaultKeymap}
+	for _, in := range decodeInput([]byte("1Qv\x1b[C")) {
+		keypad.Event(in.key)
+	}
+
+	for code := 0; code < 16; code++ {
+		want := code == 0x1 || code == 0x4 || code == 0xF
+		if keypad.Pressed(code) != want {
+			t.Errorf("key %X pressed %v, want %v", code, !want, want)
+		}
+	}
+}
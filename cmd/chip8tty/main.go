@@ -0,0 +1,284 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/andreas-jonsson/chip8studio/assembler"
+	"github.com/andreas-jonsson/chip8studio/chip8"
+	"github.com/andreas-jonsson/chip8studio/debugger"
+	"github.com/andreas-jonsson/chip8studio/display"
+	"github.com/andreas-jonsson/chip8studio/emulator"
+)
+
+var (
+	sourceFile string
+	cpuSpeed   int
+	quirks     string
+
+	chippy    *chip8.System
+	system    *emulator.Machine
+	debug     *debugger.Debugger
+	scheduler *emulator.Scheduler
+	screen    *display.Terminal
+	out       = bufio.NewWriter(os.Stdout)
+
+	emulatorPaused int32 = 1
+	turbo          int32
+	message        string
+)
+
+func init() {
+	flag.IntVar(&cpuSpeed, "speed", emulator.DefaultCPUSpeed, "instructions per second")
+	flag.StringVar(&quirks, "quirks", "", "quirks profile, or comma separated quirks, defaults to the contents of program.quirks or Chippy")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] program.asm|program.ch8\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	sourceFile = flag.Arg(0)
+
+	program, symbols, err := load(sourceFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var q chip8.Quirks
+	if quirks != "" {
+		q, err = chip8.ParseQuirks(quirks)
+	} else {
+		q, err = chip8.LoadQuirks(strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile)) + ".quirks")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		fmt.Fprintln(os.Stderr, "standard input is not a terminal")
+		os.Exit(1)
+	}
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	screen = display.NewTerminal(out)
+	system = &emulator.Machine{
+		Program:    program,
+		Display:    screen,
+		CpuSpeedHz: time.Duration(cpuSpeed),
+	}
+	system.Keypad.Map = loadKeymap()
+	chippy = chip8.NewSystem(system)
+	chippy.Quirks = q
+	debug = debugger.New(chippy)
+	debug.Symbols, debug.Program = symbols, program
+	scheduler = &emulator.Scheduler{
+		System: chippy,
+		Exec:   debug.Step,
+		Tick:   debug.Tick,
+		Speed: func() int {
+			return int(system.CpuSpeedHz)
+		},
+	}
+
+	go runEmulator()
+	err = run()
+
+	fmt.Fprint(out, "\x1b[0m\x1b[2J\x1b[H\x1b[?25h")
+	out.Flush()
+	terminal.Restore(fd, state)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// load reads a binary, or assembles a source file, and returns the program
+// and its lables.
+func load(fileName string) ([]byte, map[string]uint16, error) {
+	if strings.ToLower(filepath.Ext(fileName)) != ".asm" {
+		program, err := ioutil.ReadFile(fileName)
+		return program, nil, err
+	}
+
+	fp, err := ioutil.TempFile("", "chip8tty")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(fp.Name())
+
+	assembler.Logger = log.New(ioutil.Discard, "", 0)
+	prog, diags := assembler.AssembleFiles([]string{fileName}, fp, assembler.Options{})
+	fp.Close()
+
+	if assembler.HasErrors(diags) {
+		for _, d := range diags {
+			if d.Severity == assembler.SeverityError {
+				return nil, nil, fmt.Errorf("%v (%d error(s))", d, assembler.CountErrors(diags))
+			}
+		}
+	}
+
+	program, err := ioutil.ReadFile(fp.Name())
+	if err != nil {
+		return nil, nil, err
+	}
+	return program, prog.Lables, nil
+}
+
+// loadKeymap loads the key mapping saved by the studio next to the source
+// file, or the default mapping.
+func loadKeymap() emulator.Keymap {
+	fileName := strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile)) + ".keys"
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return emulator.DefaultKeymap
+	}
+
+	keymap, err := emulator.ParseKeymap(string(data))
+	if err != nil {
+		message = fmt.Sprintf("%s: %v", fileName, err)
+		return emulator.DefaultKeymap
+	}
+	return keymap
+}
+
+// runEmulator runs the emulator while it is not paused, like the emulator
+// goroutine of the studio.
+func runEmulator() {
+	ticker := time.NewTicker(time.Second / chip8.FrameRate)
+	for {
+		step := atomic.LoadInt32(&emulatorPaused)
+		if step != 0 || atomic.LoadInt32(&turbo) == 0 {
+			<-ticker.C
+			step = atomic.LoadInt32(&emulatorPaused)
+		}
+		if step > 0 {
+			continue
+		}
+
+		system.Lock()
+		var err error
+		if step < 0 {
+			err = scheduler.Step()
+		} else {
+			err = scheduler.Frame()
+		}
+		if err != nil {
+			if _, ok := err.(*debugger.Break); ok {
+				atomic.StoreInt32(&emulatorPaused, 1)
+			}
+			message = err.Error()
+		}
+		system.Unlock()
+
+		if step < 0 {
+			atomic.StoreInt32(&emulatorPaused, 1)
+		}
+	}
+}
+
+// build assembles the source file again and restarts the program. It is
+// called with the machine locked.
+func build() {
+	program, symbols, err := load(sourceFile)
+	if err != nil {
+		message = err.Error()
+		return
+	}
+
+	system.Program = program
+	debug.Symbols, debug.Program = symbols, program
+	chippy.Reset()
+	scheduler.Reset()
+	debug.ClearHistory()
+	message = fmt.Sprintf("%s assembled, %d bytes", filepath.Base(sourceFile), len(program))
+}
+
+func run() error {
+	input := make(chan []byte)
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(input)
+				return
+			}
+			input <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+
+	fmt.Fprint(out, "\x1b[2J\x1b[?25l")
+	ticker := time.NewTicker(time.Second / 30)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data, ok := <-input:
+			if !ok {
+				return nil
+			}
+			system.Lock()
+			quit := handleInput(data)
+			system.Unlock()
+			if quit {
+				return nil
+			}
+		case <-ticker.C:
+		}
+
+		// The display erases what is left of the panes when it shrinks, so
+		// they are drawn again after it.
+		system.Lock()
+		columns := screen.Columns()
+		chippy.Refresh()
+		drawPanes(screen.Columns() != columns)
+		system.Unlock()
+
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+)
+
+const (
+	disassemblyBefore = 3
+	disassemblyLines  = 12
+	paneWidth         = 40
+
+	helpText = "^R Run  ^N Step  ^O Over  ^U Out  ^P Pause  ^X Reset  ^T Turbo  ^B Build  ^Q Quit"
+)
+
+// panes is what drawPanes drew last, so nothing is sent to the terminal
+// while nothing changes.
+var panes []byte
+
+// drawPanes draws the registers and disassembly to the right of the
+// display, and the message and help lines below it. It is called with the
+// machine locked.
+func drawPanes(force bool) {
+	var buf bytes.Buffer
+	col := screen.Col + screen.Columns() + 2
+	row := screen.Row
+
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&buf, "\x1b[%d;%dH\x1b[0m\x1b[K", row, col)
+		fmt.Fprintf(&buf, format, args...)
+		row++
+	}
+
+	status := "Running"
+	if atomic.LoadInt32(&emulatorPaused) > 0 {
+		status = "Paused"
+	}
+	if chippy.Halted {
+		status = "Halted"
+	}
+
+	line("\x1b[1m%-8s\x1b[0m  frame %d  %v", status, chippy.Frames, chippy.Quirks)
+	line("PC $%03X  I $%03X  SP %d", chippy.PC, chippy.I, chippy.SP)
+	line("DT $%02X   ST $%02X", chippy.DT, chippy.ST)
+	for i := 0; i < 16; i += 4 {
+		v := chippy.V[i : i+4]
+		line("v%X $%02X  v%X $%02X  v%X $%02X  v%X $%02X", i, v[0], i+1, v[1], i+2, v[2], i+3, v[3])
+	}
+	line("")
+
+	pc := chippy.PC
+	for _, l := range debug.Disassemble(pc, disassemblyBefore, disassemblyLines) {
+		for _, name := range l.Lables {
+			line("\x1b[36m%s:\x1b[0m", name)
+		}
+
+		text := l.String()
+		if len(text) > paneWidth {
+			text = text[:paneWidth]
+		}
+		if l.Addr == pc {
+			line("\x1b[7m%-*s\x1b[0m", paneWidth, text)
+		} else {
+			line("%s", text)
+		}
+	}
+
+	if bottom := screen.Row + screen.Lines(); row < bottom {
+		row = bottom
+	}
+	col = screen.Col
+	row++
+	line("%s", message)
+	line("\x1b[2m%s\x1b[0m", helpText)
+	buf.WriteString("\x1b[J")
+
+	if force || !bytes.Equal(buf.Bytes(), panes) {
+		out.Write(buf.Bytes())
+		panes = buf.Bytes()
+	}
+}
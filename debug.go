@@ -0,0 +1,400 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/rect"
+	nstyle "github.com/aarzilli/nucular/style"
+
+	"golang.org/x/mobile/event/key"
+	"golang.org/x/mobile/event/mouse"
+
+	"github.com/andreas-jonsson/chip8studio/debugger"
+)
+
+var (
+	// Breakpoints are kept as lines in the project source, and resolved to
+	// addresses on every assembly. The lines are moved with the code when
+	// the source is edited, see shiftBreakpoints.
+	breakpointLines = make(map[int]bool)
+	breakpointText  []rune
+
+	highlightedPC uint16
+)
+
+func keyPressed(w *nucular.Window, code key.Code) bool {
+	for _, ev := range w.Input().Keyboard.Keys {
+		if ev.Code == code && ev.Direction != key.DirRelease {
+			return true
+		}
+	}
+	return false
+}
+
+// resume lets the emulator continue past a breakpoint at PC.
+func resume() {
+	system.Lock()
+	debug.Resume()
+	debug.Cancel()
+	system.Unlock()
+}
+
+// runUntil runs the emulator until the debugger stops it where until says.
+func runUntil(until func() bool) {
+	system.Lock()
+	debug.Resume()
+	ok := until()
+	system.Unlock()
+
+	if ok {
+		atomic.StoreInt32(&emulatorPaused, 0)
+	}
+}
+
+func stepOver() {
+	runUntil(func() bool {
+		debug.StepOver()
+		return true
+	})
+}
+
+func stepOut() {
+	runUntil(func() bool {
+		if !debug.StepOut() {
+			logger.Println("Not in a subroutine")
+			return false
+		}
+		return true
+	})
+}
+
+func runToCursor() {
+	line := cursorLine()
+	if assembly == nil {
+		logger.Println("Assemble the project before running to the cursor")
+		return
+	}
+
+	addr, ok := assembly.Address(assemblyFile, line)
+	if !ok {
+		logger.Printf("No code on %s:%d", filepath.Base(assemblyFile), line)
+		return
+	}
+
+	runUntil(func() bool {
+		debug.RunTo(addr)
+		return true
+	})
+}
+
+// updateBreakpoints resolves the breakpoint lines, and updates the symbols
+// and program of the debugger, using the last assembly. It must be called
+// with the system locked.
+func updateBreakpoints() {
+	var addrs []uint16
+	if assembly != nil {
+		for line := range breakpointLines {
+			if addr, ok := assembly.Address(assemblyFile, line); ok {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	debug.SetBreakpoints(addrs)
+
+	if assembly != nil {
+		debug.Symbols = assembly.Lables
+	}
+	debug.Program = system.Program
+}
+
+// shiftBreakpoints moves the breakpoint lines with the code, when the
+// project source has changed since the last call.
+func shiftBreakpoints() {
+	if string(breakpointText) == string(textEditor.Buffer) {
+		return
+	}
+	breakpointLines = shiftLines(breakpointLines, breakpointText, textEditor.Buffer)
+	breakpointText = append(breakpointText[:0], textEditor.Buffer...)
+}
+
+// shiftLines returns lines renumbered for the edit that turned old into
+// text. The edit is taken to be the span between the common prefix and
+// suffix of the two. A line follows its line break, so lines after the
+// edit are shifted by the number of line breaks added or removed, and a
+// line that is removed completely is dropped.
+func shiftLines(lines map[int]bool, old, text []rune) map[int]bool {
+	prefix := 0
+	for prefix < len(old) && prefix < len(text) && old[prefix] == text[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(text)-prefix && old[len(old)-1-suffix] == text[len(text)-1-suffix] {
+		suffix++
+	}
+	oldEnd, textEnd := len(old)-suffix, len(text)-suffix
+	delta := countLines(text[prefix:textEnd]) - countLines(old[prefix:oldEnd])
+
+	shifted := make(map[int]bool, len(lines))
+	start, line := 0, 1
+	for i := 0; i <= len(old); i++ {
+		if i < len(old) && old[i] != '\n' {
+			continue
+		}
+		switch {
+		case !lines[line]:
+		case i < prefix:
+			shifted[line] = true
+		case i >= oldEnd:
+			shifted[line+delta] = true
+		case start < prefix:
+			// The line break was edited, but the line still starts
+			// where it did.
+			shifted[line] = true
+		}
+		start, line = i+1, line+1
+	}
+	return shifted
+}
+
+func countLines(text []rune) int {
+	n := 0
+	for _, r := range text {
+		if r == '\n' {
+			n++
+		}
+	}
+	return n
+}
+
+// cursorLine returns the line of the cursor in the project editor.
+func cursorLine() int {
+	line := 1
+	for i, r := range textEditor.Buffer {
+		if i >= textEditor.Cursor {
+			break
+		}
+		if r == '\n' {
+			line++
+		}
+	}
+	return line
+}
+
+// lineRange returns the start and end of line in the project editor.
+func lineRange(line int) (int, int) {
+	start, n := 0, 1
+	for i, r := range textEditor.Buffer {
+		if r != '\n' {
+			continue
+		}
+		if n == line {
+			return start, i
+		}
+		start, n = i+1, n+1
+	}
+	return start, len(textEditor.Buffer)
+}
+
+func toggleBreakpoint() {
+	toggleBreakpointLine(cursorLine())
+}
+
+func toggleBreakpointLine(line int) {
+	name := filepath.Base(assemblyFile)
+
+	if breakpointLines[line] {
+		delete(breakpointLines, line)
+		logger.Printf("Breakpoint removed from %s:%d", name, line)
+	} else if assembly == nil {
+		logger.Println("Assemble the project before setting breakpoints")
+	} else if addr, ok := assembly.Address(assemblyFile, line); ok {
+		breakpointLines[line] = true
+		logger.Printf("Breakpoint at %s:%d ($%03X)", name, line, addr)
+	} else {
+		logger.Printf("No code on %s:%d", name, line)
+	}
+
+	system.Lock()
+	updateBreakpoints()
+	system.Unlock()
+	masterWindow.Changed()
+}
+
+const gutterWidth = 14
+
+var gutterBreakpoint = color.RGBA{0xE0, 0x30, 0x30, 0xFF}
+
+// editorUpdate lays out the project editor with a gutter to the left of it.
+// The gutter has a marker on every breakpoint line, and a click in it
+// toggles the breakpoint on that line.
+func editorUpdate(w *nucular.Window, height int) {
+	w.Row(height).Static(gutterWidth, w.Bounds.W-15-gutterWidth)
+	gutter, out := w.Custom(nstyle.WidgetStateInactive)
+	editor := w.WidgetBounds()
+	textEditor.Edit(w)
+	shiftBreakpoints()
+
+	// Lines are laid out the way the editor draws them.
+	style := w.Master().Style()
+	rowHeight := nucular.FontHeight(style.Font) + style.Edit.RowPadding
+	top := editor.Y + style.Edit.Padding.Y + style.Edit.Border - textEditor.Scrollbar.Y
+	if rowHeight <= 0 {
+		return
+	}
+
+	if in := w.Input(); in != nil && in.Mouse.Clicked(mouse.ButtonLeft, gutter) {
+		if y := in.Mouse.Pos.Y - top; y >= 0 {
+			toggleBreakpointLine(y/rowHeight + 1)
+		}
+	}
+
+	if out == nil {
+		return
+	}
+	for line := range breakpointLines {
+		y := top + (line-1)*rowHeight
+		if y+rowHeight <= editor.Y || y >= editor.Y+editor.H {
+			continue
+		}
+		size := rowHeight - 4
+		if size > gutter.W-4 {
+			size = gutter.W - 4
+		}
+		marker := rect.Rect{X: gutter.X + (gutter.W-size)/2, Y: y + (rowHeight-size)/2, W: size, H: size}
+		out.FillRect(marker, uint16(size/2), gutterBreakpoint)
+	}
+}
+
+// highlightPC selects the source line of PC in the project editor, when
+// the emulator stops somewhere new.
+func highlightPC() {
+	if atomic.LoadInt32(&emulatorPaused) <= 0 || assembly == nil {
+		return
+	}
+
+	system.Lock()
+	pc := chippy.PC
+	system.Unlock()
+
+	if pc == highlightedPC {
+		return
+	}
+	highlightedPC = pc
+
+	if loc, ok := assembly.Location(pc); ok && loc.File == assemblyFile {
+		start, end := lineRange(loc.Line)
+		textEditor.SelectStart, textEditor.SelectEnd = start, end
+		textEditor.Cursor = start
+	}
+}
+
+func dumpBreakpoints(w io.Writer) {
+	var lines []int
+	for line := range breakpointLines {
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return
+	}
+	sort.Ints(lines)
+
+	fmt.Fprint(w, "\nBreakpoints:")
+	for _, line := range lines {
+		if addr, ok := assembly.Address(assemblyFile, line); ok {
+			fmt.Fprintf(w, " %d ($%03X)", line, addr)
+		} else {
+			fmt.Fprintf(w, " %d", line)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// addWatchpoint adds the watchpoint typed in the Debug window.
+func addWatchpoint() {
+	wp, err := debugger.ParseWatchpoint(string(watchEditor.Buffer))
+	if err != nil {
+		logger.Println(err)
+		return
+	}
+
+	system.Lock()
+	debug.AddWatchpoint(wp)
+	system.Unlock()
+
+	logger.Printf("Watching %s", wp.Spec)
+	watchEditor.Buffer = nil
+}
+
+func dumpWatchpoints(w io.Writer) {
+	watchpoints := debug.Watchpoints()
+	if len(watchpoints) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "\nWatchpoints:")
+	for _, wp := range watchpoints {
+		fmt.Fprintf(w, "  %s\n", wp)
+	}
+}
+
+const (
+	disassemblyBefore = 6
+	disassemblyLines  = 24
+)
+
+var (
+	disassemblyPC       = color.RGBA{0xFF, 0x60, 0x60, 0xFF}
+	disassemblyModified = color.RGBA{0xFF, 0xA0, 0x40, 0xFF}
+	disassemblyCode     = color.RGBA{0xC0, 0xC0, 0xC0, 0xFF}
+	disassemblyLable    = color.RGBA{0xFF, 0xD0, 0x60, 0xFF}
+)
+
+// disassemblyUpdate draws the memory around PC, decoded as instructions.
+// Lines marked with * differ from what the assembler emitted.
+func disassemblyUpdate(w *nucular.Window) {
+	system.Lock()
+	pc := chippy.PC
+	lines := debug.Disassemble(pc, disassemblyBefore, disassemblyLines)
+	system.Unlock()
+
+	for _, line := range lines {
+		for _, name := range line.Lables {
+			w.Row(16).Dynamic(1)
+			w.LabelColored(name+":", "LC", disassemblyLable)
+		}
+
+		c := disassemblyCode
+		switch {
+		case line.Addr == pc:
+			c = disassemblyPC
+		case line.Modified:
+			c = disassemblyModified
+		}
+
+		w.Row(16).Dynamic(1)
+		w.LabelColored(line.String(), "LC", c)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShiftLines(t *testing.T) {
+	const source = "a\nb\nc\nd\n"
+	tests := []struct {
+		name  string
+		text  string
+		lines []int
+	}{
+		{"unchanged", source, []int{2, 4}},
+		{"edit inside line", "a\nbb\nc\nd\n", []int{2, 4}},
+		{"insert line above", "x\na\nb\nc\nd\n", []int{3, 5}},
+		{"insert line break at start", "a\n\nb\nc\nd\n", []int{3, 5}},
+		{"insert line break at end", "a\nb\n\nc\nd\n", []int{2, 5}},
+		{"insert line below", "a\nb\nc\nd\nx\n", []int{2, 4}},
+		{"remove line above", "b\nc\nd\n", []int{1, 3}},
+		{"remove breakpoint line", "a\nc\nd\n", []int{3}},
+		{"join with next line", "a\nbc\nd\n", []int{2, 3}},
+		{"remove all", "", []int{}},
+	}
+
+	for _, tt := range tests {
+		lines := shiftLines(map[int]bool{2: true, 4: true}, []rune(source), []rune(tt.text))
+		want := make(map[int]bool)
+		for _, line := range tt.lines {
+			want[line] = true
+		}
+		if !reflect.DeepEqual(lines, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, lines, want)
+		}
+	}
+}
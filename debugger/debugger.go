@@ -0,0 +1,189 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package debugger
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/andreas-jonsson/chip8studio/chip8"
+)
+
+// Break is returned by Step when execution stops. PC is where execution
+// stopped, or for watchpoints the instruction that triggered it.
+type Break struct {
+	PC     uint16
+	Reason string
+}
+
+func (b *Break) Error() string {
+	return fmt.Sprintf("%s at $%03X", b.Reason, b.PC)
+}
+
+type Debugger struct {
+	System *chip8.System
+
+	// Symbols maps names to addresses, for commands that take an address.
+	Symbols map[string]uint16
+
+	// Program is the binary as assembled, to tell where memory has changed.
+	Program []byte
+
+	breakpoints map[uint16]bool
+	resumed     bool
+
+	watchpoints []*Watchpoint
+	accesses    []access
+
+	until *target
+
+	history history
+}
+
+// target is where a step over, step out or run to cursor stops.
+type target struct {
+	reason  string
+	depth   int
+	addr    uint16
+	hasAddr bool
+}
+
+func New(sys *chip8.System) *Debugger {
+	d := &Debugger{
+		System:      sys,
+		breakpoints: make(map[uint16]bool),
+	}
+	d.SetHistorySize(DefaultHistorySize)
+	sys.OnAccess = d.access
+	return d
+}
+
+// SetBreakpoints replaces all breakpoints.
+func (d *Debugger) SetBreakpoints(addrs []uint16) {
+	d.breakpoints = make(map[uint16]bool, len(addrs))
+	for _, addr := range addrs {
+		d.breakpoints[addr] = true
+	}
+}
+
+// Breakpoints returns the address of every breakpoint, sorted.
+func (d *Debugger) Breakpoints() []uint16 {
+	var addrs []uint16
+	for addr := range d.breakpoints {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// Resume lets the next Step execute the instruction at PC even if there is
+// a breakpoint on it, so execution can continue after a break.
+func (d *Debugger) Resume() {
+	d.resumed = true
+}
+
+// Depth returns the number of subroutine calls the program is in.
+func (d *Debugger) Depth() int {
+	return d.System.SP
+}
+
+// StepOver stops execution after the next instruction, running a call to
+// completion as if it was a single instruction.
+func (d *Debugger) StepOver() {
+	d.until = &target{reason: "step over", depth: d.Depth()}
+}
+
+// StepOut stops execution when the current subroutine returns. It returns
+// false if the program is not in a subroutine.
+func (d *Debugger) StepOut() bool {
+	if d.Depth() == 0 {
+		return false
+	}
+	d.until = &target{reason: "step out", depth: d.Depth() - 1}
+	return true
+}
+
+// RunTo stops execution before the instruction at addr.
+func (d *Debugger) RunTo(addr uint16) {
+	d.until = &target{reason: "run to cursor", depth: -1, addr: addr, hasAddr: true}
+}
+
+// Cancel forgets any step over, step out or run to cursor in progress.
+func (d *Debugger) Cancel() {
+	d.until = nil
+}
+
+// Step executes one instruction. It returns a *Break, without executing
+// anything, if there is a breakpoint at PC, or after executing it if it
+// triggers a watchpoint or completes a step over or step out.
+func (d *Debugger) Step() error {
+	err := d.step()
+	if err != nil {
+		d.until = nil
+	}
+	return err
+}
+
+// Tick ends a frame by running the timers of the system. It returns a
+// *Break if the timers trigger a watchpoint on dt or st.
+func (d *Debugger) Tick() error {
+	sys := d.System
+	before := sys.Registers
+	d.accesses = d.accesses[:0]
+
+	sys.Tick()
+	if err := d.checkWatchpoints(sys.PC, &before); err != nil {
+		d.until = nil
+		return err
+	}
+	return nil
+}
+
+func (d *Debugger) step() error {
+	sys := d.System
+	idle := sys.Halted || sys.VBlankWait
+	if !d.resumed && !idle {
+		if d.breakpoints[sys.PC] {
+			return &Break{sys.PC, "breakpoint"}
+		}
+		if u := d.until; u != nil && u.hasAddr && u.addr == sys.PC {
+			return &Break{sys.PC, u.reason}
+		}
+	}
+	d.resumed = false
+
+	pc, before := sys.PC, sys.Registers
+	d.accesses = d.accesses[:0]
+	if !idle {
+		d.record()
+	}
+
+	err := sys.Step()
+	d.history.current = nil
+	if err != nil {
+		return err
+	}
+	if err := d.checkWatchpoints(pc, &before); err != nil {
+		return err
+	}
+
+	if u := d.until; u != nil && d.Depth() <= u.depth {
+		return &Break{sys.PC, u.reason}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package debugger
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/andreas-jonsson/chip8studio/chip8"
+	"github.com/andreas-jonsson/chip8studio/disassembler"
+)
+
+// Line is an instruction in a disassembly.
+type Line struct {
+	Addr   uint16
+	Opcode uint16
+	Text   string
+	Lables []string
+
+	// Modified is true if memory differs from the program as assembled.
+	Modified bool
+}
+
+func (l Line) String() string {
+	mark := ' '
+	if l.Modified {
+		mark = '*'
+	}
+	return fmt.Sprintf("%c%03X  %04X  %s", mark, l.Addr, l.Opcode, l.Text)
+}
+
+func (d *Debugger) lablesByAddr() map[uint16][]string {
+	lables := make(map[uint16][]string)
+	for name, addr := range d.Symbols {
+		lables[addr] = append(lables[addr], name)
+	}
+	for _, names := range lables {
+		sort.Strings(names)
+	}
+	return lables
+}
+
+// modified returns true if the two bytes at addr differ from Program.
+func (d *Debugger) modified(addr uint16) bool {
+	for i := 0; i < 2; i++ {
+		a := int(addr) + i
+		offset := a - chip8.ProgramStart
+		if offset < 0 || offset >= len(d.Program) || a >= chip8.MemorySize {
+			continue
+		}
+		if d.System.Memory[a] != d.Program[offset] {
+			return true
+		}
+	}
+	return false
+}
+
+// Disassemble decodes count instructions of memory, starting before
+// instructions ahead of addr. Instructions are assumed to be aligned with
+// addr, as there is no telling where they start in memory.
+func (d *Debugger) Disassemble(addr uint16, before, count int) []Line {
+	lables := d.lablesByAddr()
+	symbol := func(addr uint16) (string, bool) {
+		if names := lables[addr]; len(names) > 0 {
+			return names[0], true
+		}
+		return "", false
+	}
+
+	start := int(addr) - 2*before
+	for start < 0 {
+		start += 2
+	}
+
+	var lines []Line
+	for a := start; len(lines) < count && a+1 < chip8.MemorySize; a += 2 {
+		op := d.System.Opcode(uint16(a))
+		line := Line{
+			Addr:     uint16(a),
+			Opcode:   op,
+			Lables:   lables[uint16(a)],
+			Modified: d.modified(uint16(a)),
+		}
+
+		if inst, ok := disassembler.Decode(op); ok {
+			line.Text = inst.Format(symbol)
+		} else {
+			line.Text = fmt.Sprintf("%-8s$%02x $%02x", ".", op>>8, op&0xFF)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/andreas-jonsson/chip8studio/chip8"
+)
+
+// number parses a number or the name of a symbol.
+func (d *Debugger) number(s string) (int, error) {
+	if addr, ok := d.Symbols[s]; ok {
+		return int(addr), nil
+	}
+	return parseNumber(s)
+}
+
+// Set changes a register or a byte in memory. The assignment is written
+// as target = value, where target is v0-vF, i, pc, dt, st or an address.
+// Addresses and values may be given as symbols. The change is recorded in
+// the history, so StepBack undoes it like an instruction.
+func (d *Debugger) Set(assignment string) error {
+	parts := strings.SplitN(assignment, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected 'target = value', got '%s'", assignment)
+	}
+
+	name := strings.TrimSpace(parts[0])
+	target := strings.ToLower(name)
+	n, err := d.number(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return err
+	}
+
+	sys := d.System
+	var set func()
+	switch {
+	case target == "i":
+		if n > 0xFFFF {
+			return fmt.Errorf("value $%X does not fit in i", n)
+		}
+		set = func() { sys.I = uint16(n) }
+	case target == "pc":
+		if n > chip8.MemorySize-2 {
+			return fmt.Errorf("pc $%X is outside memory", n)
+		}
+		set = func() { sys.PC = uint16(n) }
+	case n > 0xFF:
+		return fmt.Errorf("value $%X does not fit in %s", n, target)
+	case target == "dt":
+		set = func() { sys.DT = byte(n) }
+	case target == "st":
+		set = func() { sys.ST = byte(n) }
+	case isRegister(target):
+		r, _ := strconv.ParseUint(target[1:], 16, 4)
+		set = func() { sys.V[r] = byte(n) }
+	default:
+		addr, err := d.number(name)
+		if err != nil || addr >= chip8.MemorySize {
+			return fmt.Errorf("unknown target '%s'", name)
+		}
+		set = func() {
+			d.recordWrite(uint16(addr))
+			sys.Memory[addr] = byte(n)
+		}
+	}
+
+	d.recordEdit(fmt.Sprintf("set %s = $%X", name, n))
+	set()
+	d.history.current = nil
+	return nil
+}
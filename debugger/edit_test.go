@@ -0,0 +1,109 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package debugger
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/andreas-jonsson/chip8studio/chip8"
+)
+
+// testMachine runs a program given as opcodes.
+type testMachine struct {
+	program []byte
+	tone    bool
+	width   int
+}
+
+func (m *testMachine) Load(memory []byte)    { copy(memory, m.program) }
+func (m *testMachine) Rand() *rand.Rand      { return rand.New(rand.NewSource(1)) }
+func (m *testMachine) BeginTone()            { m.tone = true }
+func (m *testMachine) EndTone()              { m.tone = false }
+func (m *testMachine) Key(code int) bool     { return false }
+func (m *testMachine) SetCPUFrequency(int)   {}
+func (m *testMachine) ResizeVideo(width int) { m.width = width }
+func (m *testMachine) Draw(video []byte)     {}
+
+func newTestMachine(opcodes ...uint16) *testMachine {
+	m := &testMachine{}
+	for _, op := range opcodes {
+		m.program = append(m.program, byte(op>>8), byte(op))
+	}
+	return m
+}
+
+func newDebugger(opcodes ...uint16) *Debugger {
+	return New(chip8.NewSystem(newTestMachine(opcodes...)))
+}
+
+func TestSetStepBack(t *testing.T) {
+	// load v0 1, load i $300, load v0 2
+	d := newDebugger(0x6001, 0xA300, 0x6002)
+	sys := d.System
+	if err := d.Step(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, assignment := range []string{"v0 = $10", "$300 = 7", "i = $2F0"} {
+		if err := d.Set(assignment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if d.HistoryLen() != 5 {
+		t.Fatalf("%d entries in history, want 5", d.HistoryLen())
+	}
+
+	entries := d.History(5)
+	if !entries[1].Edit || entries[1].Text != "set v0 = $10" || entries[0].Edit || entries[4].Edit {
+		t.Errorf("history %v", entries)
+	}
+
+	// Undo the loadi and the edit of i, leaving memory and v0 edited.
+	d.StepBack()
+	d.StepBack()
+	if sys.I != 0 || sys.V[0] != 0x10 || sys.Memory[0x300] != 7 {
+		t.Fatalf("I = $%03X, v0 = $%02X, [$300] = %d", sys.I, sys.V[0], sys.Memory[0x300])
+	}
+
+	d.StepBack()
+	d.StepBack()
+	if sys.V[0] != 1 || sys.Memory[0x300] != 0 || sys.PC != 0x202 {
+		t.Fatalf("v0 = $%02X, [$300] = %d, PC = $%03X", sys.V[0], sys.Memory[0x300], sys.PC)
+	}
+
+	d.StepBack()
+	if sys.V[0] != 0 || sys.PC != 0x200 || d.StepBack() {
+		t.Fatalf("v0 = $%02X, PC = $%03X after undoing everything", sys.V[0], sys.PC)
+	}
+}
+
+func TestSetErrors(t *testing.T) {
+	d := newDebugger()
+	for _, assignment := range []string{"v0", "v0 = $100", "pc = $FFF", "i = $10000", "vg = 1", "$1000 = 1", "v1 = nothing"} {
+		if err := d.Set(assignment); err == nil {
+			t.Errorf("%s: no error", assignment)
+		}
+	}
+	if d.HistoryLen() != 0 {
+		t.Errorf("failed edits were recorded")
+	}
+}
@@ -0,0 +1,311 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package debugger
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andreas-jonsson/chip8studio/chip8"
+	"github.com/andreas-jonsson/chip8studio/disassembler"
+)
+
+const DefaultHistorySize = 4096
+
+type memoryWrite struct {
+	addr uint16
+	old  byte
+}
+
+// display is the part of the state changed by display instructions, with
+// the video packed to one bit per pixel.
+type display struct {
+	highRes, halted bool
+	vblankWait      bool
+	bg, fg          byte
+	video           [len(chip8.State{}.Video) / 8]byte
+}
+
+// record holds what is needed to undo one instruction. Instead of a copy of
+// the whole machine, it keeps the registers, the old value of every byte
+// written, and the display only if the instruction could change it. Edits
+// made in the debugger are recorded the same way, with edit describing
+// the change.
+type record struct {
+	regs    chip8.Registers
+	frames  uint64
+	sprite  sprite
+	writes  []memoryWrite
+	display *display
+	state   *chip8.State
+	edit    string
+}
+
+// sprite is the last sprite drawn, which the memory view shows.
+type sprite struct {
+	addr        uint16
+	width, size int
+}
+
+// history is a ring buffer of records, oldest first.
+type history struct {
+	records []record
+	start   int
+	count   int
+	current *record
+}
+
+func (h *history) push() *record {
+	i := (h.start + h.count) % len(h.records)
+	if h.count == len(h.records) {
+		h.start = (h.start + 1) % len(h.records)
+	} else {
+		h.count++
+	}
+
+	r := &h.records[i]
+	*r = record{writes: r.writes[:0]}
+	return r
+}
+
+func (h *history) pop() *record {
+	if h.count == 0 {
+		return nil
+	}
+	h.count--
+	return &h.records[(h.start+h.count)%len(h.records)]
+}
+
+// at returns record i, counting from the oldest.
+func (h *history) at(i int) *record {
+	return &h.records[(h.start+i)%len(h.records)]
+}
+
+func saveDisplay(st *chip8.State) *display {
+	dp := &display{highRes: st.HighRes, halted: st.Halted, vblankWait: st.VBlankWait, bg: st.BG, fg: st.FG}
+	for i, p := range st.Video {
+		dp.video[i/8] |= p << uint(i%8)
+	}
+	return dp
+}
+
+func (dp *display) restore(st *chip8.State) {
+	st.HighRes, st.Halted, st.VBlankWait, st.BG, st.FG = dp.highRes, dp.halted, dp.vblankWait, dp.bg, dp.fg
+	for i := range st.Video {
+		st.Video[i] = dp.video[i/8] >> uint(i%8) & 1
+	}
+}
+
+// SetHistorySize sets how many instructions can be undone. Zero disables
+// the history.
+func (d *Debugger) SetHistorySize(n int) {
+	d.history = history{records: make([]record, n)}
+}
+
+// HistoryLen returns the number of instructions that can be undone.
+func (d *Debugger) HistoryLen() int {
+	return d.history.count
+}
+
+// ClearHistory forgets all executed instructions, as when the machine is
+// reset or loaded with a different state.
+func (d *Debugger) ClearHistory() {
+	d.history.start, d.history.count = 0, 0
+}
+
+// record saves what is needed to undo the instruction at PC.
+func (d *Debugger) record() {
+	h := &d.history
+	h.current = nil
+	if len(h.records) == 0 {
+		return
+	}
+
+	sys := d.System
+	r := h.push()
+	r.regs, r.frames = sys.Registers, sys.Frames
+	r.sprite = sprite{sys.SpriteAddr, sys.SpriteWidth, sys.SpriteSize}
+
+	switch op := sys.Opcode(sys.PC); {
+	case op>>12 == 0xD, op == 0x00E0, op&0xFFF0 == 0x00C0, op >= 0x00FB && op <= 0x00FF:
+		r.display = saveDisplay(&sys.State)
+	case op>>12 == 0, op == 0xF002, op&0xF0FF == 0xF03A:
+		// System calls can do anything, including a reset, and audio
+		// instructions change state not covered by the other cases.
+		state := sys.State
+		r.state = &state
+	}
+	h.current = r
+}
+
+// recordEdit saves what is needed to undo an edit of registers or memory.
+func (d *Debugger) recordEdit(edit string) {
+	h := &d.history
+	h.current = nil
+	if len(h.records) == 0 {
+		return
+	}
+
+	sys := d.System
+	r := h.push()
+	r.regs, r.frames, r.edit = sys.Registers, sys.Frames, edit
+	r.sprite = sprite{sys.SpriteAddr, sys.SpriteWidth, sys.SpriteSize}
+	h.current = r
+}
+
+func (d *Debugger) recordWrite(addr uint16) {
+	if r := d.history.current; r != nil {
+		r.writes = append(r.writes, memoryWrite{addr, d.System.Memory[addr]})
+	}
+}
+
+// StepBack undoes the last instruction, or edit made with Set. The state
+// before it is loaded with SetState, so the tone and video size follow. It
+// returns false if there is no history left.
+func (d *Debugger) StepBack() bool {
+	r := d.history.pop()
+	if r == nil {
+		return false
+	}
+
+	sys := d.System
+	st := sys.State
+	if r.state != nil {
+		st = *r.state
+	} else {
+		for i := len(r.writes) - 1; i >= 0; i-- {
+			st.Memory[r.writes[i].addr] = r.writes[i].old
+		}
+		if r.display != nil {
+			r.display.restore(&st)
+		}
+		st.Registers, st.Frames = r.regs, r.frames
+	}
+
+	sys.SetState(st)
+	sys.SpriteAddr, sys.SpriteWidth, sys.SpriteSize = r.sprite.addr, r.sprite.width, r.sprite.size
+	d.history.current = nil
+	d.resumed = true
+	return true
+}
+
+// Rewind undoes instructions until frames 60 Hz frames have been undone, or
+// the history runs out. It returns the number of instructions undone.
+func (d *Debugger) Rewind(frames int) int {
+	target := int64(d.System.Frames) - int64(frames)
+	n := 0
+	for int64(d.System.Frames) > target && d.StepBack() {
+		n++
+	}
+	return n
+}
+
+// Entry is an executed instruction in the history, or an edit made with
+// Set if Edit is true.
+type Entry struct {
+	Addr    uint16
+	Opcode  uint16
+	Text    string
+	Changes []string
+	Edit    bool
+}
+
+func (e Entry) String() string {
+	op := fmt.Sprintf("%04X", e.Opcode)
+	if e.Edit {
+		op = "----"
+	}
+	s := fmt.Sprintf("%03X  %s  %-20s %s", e.Addr, op, e.Text, strings.Join(e.Changes, " "))
+	return strings.TrimRight(s, " ")
+}
+
+// changes describes how the registers changed from before to after.
+func changes(before, after *chip8.Registers) []string {
+	var c []string
+	for i := range before.V {
+		if before.V[i] != after.V[i] {
+			c = append(c, fmt.Sprintf("v%X $%02X->$%02X", i, before.V[i], after.V[i]))
+		}
+	}
+	if before.I != after.I {
+		c = append(c, fmt.Sprintf("I $%03X->$%03X", before.I, after.I))
+	}
+	if before.SP != after.SP {
+		c = append(c, fmt.Sprintf("SP %d->%d", before.SP, after.SP))
+	}
+	if before.DT != after.DT {
+		c = append(c, fmt.Sprintf("DT $%02X->$%02X", before.DT, after.DT))
+	}
+	if before.ST != after.ST {
+		c = append(c, fmt.Sprintf("ST $%02X->$%02X", before.ST, after.ST))
+	}
+	return c
+}
+
+// History returns the last n executed instructions, oldest first, with the
+// registers and memory they changed.
+func (d *Debugger) History(n int) []Entry {
+	h := &d.history
+	if n > h.count {
+		n = h.count
+	}
+
+	lables := d.lablesByAddr()
+	symbol := func(addr uint16) (string, bool) {
+		if names := lables[addr]; len(names) > 0 {
+			return names[0], true
+		}
+		return "", false
+	}
+
+	// Walk backwards from the current state, undoing writes on a copy of
+	// memory, so every instruction is decoded from memory as it was when
+	// it was fetched.
+	memory := d.System.Memory
+	after := &d.System.Registers
+	entries := make([]Entry, n)
+
+	for i := n - 1; i >= 0; i-- {
+		r := h.at(h.count - n + i)
+		e := Entry{Addr: r.regs.PC, Changes: changes(&r.regs, after)}
+
+		for _, w := range r.writes {
+			e.Changes = append(e.Changes, fmt.Sprintf("[$%03X] $%02X->$%02X", w.addr, w.old, memory[w.addr]))
+		}
+		for j := len(r.writes) - 1; j >= 0; j-- {
+			memory[r.writes[j].addr] = r.writes[j].old
+		}
+		if r.state != nil {
+			memory = r.state.Memory
+		}
+
+		pc := r.regs.PC
+		e.Opcode = uint16(memory[pc%chip8.MemorySize])<<8 | uint16(memory[(pc+1)%chip8.MemorySize])
+		if r.edit != "" {
+			e.Text, e.Edit = r.edit, true
+		} else if inst, ok := disassembler.Decode(e.Opcode); ok {
+			e.Text = inst.Format(symbol)
+		} else {
+			e.Text = fmt.Sprintf("%-8s$%02x $%02x", ".", e.Opcode>>8, e.Opcode&0xFF)
+		}
+
+		entries[i] = e
+		after = &r.regs
+	}
+	return entries
+}
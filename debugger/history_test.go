@@ -0,0 +1,71 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package debugger
+
+import (
+	"testing"
+
+	"github.com/andreas-jonsson/chip8studio/chip8"
+)
+
+func step(t *testing.T, d *Debugger, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := d.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStepBackState(t *testing.T) {
+	// load v0 5, loads v0, high, loadi 0, draw v1 v2 5
+	m := newTestMachine(0x6005, 0xF018, 0x00FF, 0xA000, 0xD125)
+	d := New(chip8.NewSystem(m))
+	sys := d.System
+	step(t, d, 5)
+	if !m.tone || m.width != chip8.HighResWidth || sys.SpriteSize != 5 {
+		t.Fatalf("got tone %v, width %d and sprite size %d after running", m.tone, m.width, sys.SpriteSize)
+	}
+
+	d.StepBack()
+	if sys.SpriteAddr != 0 || sys.SpriteWidth != 0 || sys.SpriteSize != 0 {
+		t.Errorf("got sprite $%03X, %d, %d, want none", sys.SpriteAddr, sys.SpriteWidth, sys.SpriteSize)
+	}
+	for i, p := range sys.Video {
+		if p != 0 {
+			t.Fatalf("pixel %d is still set", i)
+		}
+	}
+
+	d.StepBack()
+	d.StepBack()
+	if m.width != chip8.LowResWidth || sys.HighRes {
+		t.Errorf("got width %d after undoing high", m.width)
+	}
+
+	d.StepBack()
+	if m.tone || sys.ST != 0 {
+		t.Errorf("got tone %v and ST %d after undoing loads", m.tone, sys.ST)
+	}
+
+	// Running forward again gives the same state.
+	step(t, d, 3)
+	if !m.tone || m.width != chip8.HighResWidth {
+		t.Errorf("got tone %v and width %d after running again", m.tone, m.width)
+	}
+}
@@ -0,0 +1,285 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/andreas-jonsson/chip8studio/chip8"
+)
+
+type Access int
+
+const (
+	Read Access = 1 << iota
+	Write
+)
+
+// Watchpoint stops execution when a register changes, or a memory range is
+// accessed, and the value satisfies the condition.
+//
+// A watchpoint is written as a target, an optional condition and an
+// optional hit count:
+//
+//	vE                  vE changes
+//	vE > 99             vE changes to a value above 99
+//	dt == 0 hits 3      the third time the delay timer reaches zero
+//	$2FC..$2FF          any byte in $2FC-$2FF is written
+//	$2FC:rw != $00      $2FC is read or written while not zero
+//
+// Register targets are v0-vF, i, dt and st. Memory targets are an address
+// or a range, followed by :r, :w or :rw. The default is :w.
+type Watchpoint struct {
+	Spec string
+
+	// Register is the watched register, or empty for memory watchpoints.
+	Register   string
+	Start, End uint16
+	Access     Access
+
+	// Op is one of == != < <= > >=, or empty for no condition.
+	Op    string
+	Value int
+
+	// The watchpoint stops execution from hit number After and on.
+	After int
+	Hits  int
+}
+
+type access struct {
+	addr  uint16
+	write bool
+}
+
+func parseNumber(s string) (int, error) {
+	var (
+		n   uint64
+		err error
+	)
+
+	switch {
+	case strings.HasPrefix(s, "$"):
+		n, err = strconv.ParseUint(s[1:], 16, 16)
+	case strings.HasPrefix(s, "%"):
+		n, err = strconv.ParseUint(s[1:], 2, 16)
+	default:
+		n, err = strconv.ParseUint(s, 0, 16)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid number '%s'", s)
+	}
+	return int(n), nil
+}
+
+func isRegister(s string) bool {
+	switch s {
+	case "i", "dt", "st":
+		return true
+	}
+	if len(s) == 2 && s[0] == 'v' {
+		_, err := strconv.ParseUint(s[1:], 16, 4)
+		return err == nil
+	}
+	return false
+}
+
+// ParseWatchpoint parses a watchpoint as described by Watchpoint.
+func ParseWatchpoint(spec string) (*Watchpoint, error) {
+	wp := &Watchpoint{Spec: strings.TrimSpace(spec), After: 1}
+	fields := strings.Fields(wp.Spec)
+
+	if n := len(fields); n > 2 && fields[n-2] == "hits" {
+		after, err := strconv.Atoi(fields[n-1])
+		if err != nil || after < 1 {
+			return nil, fmt.Errorf("invalid hit count '%s'", fields[n-1])
+		}
+		wp.After = after
+		fields = fields[:n-2]
+	}
+
+	switch len(fields) {
+	case 1:
+	case 3:
+		switch op := fields[1]; op {
+		case "==", "!=", "<", "<=", ">", ">=":
+			wp.Op = op
+		default:
+			return nil, fmt.Errorf("unknown operator '%s'", op)
+		}
+
+		n, err := parseNumber(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		wp.Value = n
+	default:
+		return nil, fmt.Errorf("expected 'target [op value] [hits n]', got '%s'", wp.Spec)
+	}
+
+	target := strings.ToLower(fields[0])
+	if isRegister(target) {
+		wp.Register = target
+		return wp, nil
+	}
+
+	wp.Access = Write
+	if i := strings.IndexByte(target, ':'); i >= 0 {
+		switch target[i+1:] {
+		case "r":
+			wp.Access = Read
+		case "w":
+			wp.Access = Write
+		case "rw", "wr":
+			wp.Access = Read | Write
+		default:
+			return nil, fmt.Errorf("unknown access '%s', expected r, w or rw", target[i+1:])
+		}
+		target = target[:i]
+	}
+
+	bounds := strings.SplitN(target, "..", 2)
+	start, err := parseNumber(bounds[0])
+	if err != nil {
+		return nil, fmt.Errorf("unknown watch target '%s'", fields[0])
+	}
+
+	end := start
+	if len(bounds) == 2 {
+		if end, err = parseNumber(bounds[1]); err != nil {
+			return nil, err
+		}
+	}
+	if start > end || end >= chip8.MemorySize {
+		return nil, fmt.Errorf("invalid memory range $%03X..$%03X", start, end)
+	}
+
+	wp.Start, wp.End = uint16(start), uint16(end)
+	return wp, nil
+}
+
+func (wp *Watchpoint) test(n int) bool {
+	switch wp.Op {
+	case "==":
+		return n == wp.Value
+	case "!=":
+		return n != wp.Value
+	case "<":
+		return n < wp.Value
+	case "<=":
+		return n <= wp.Value
+	case ">":
+		return n > wp.Value
+	case ">=":
+		return n >= wp.Value
+	}
+	return true
+}
+
+func (wp *Watchpoint) register(regs *chip8.Registers) int {
+	switch wp.Register {
+	case "i":
+		return int(regs.I)
+	case "dt":
+		return int(regs.DT)
+	case "st":
+		return int(regs.ST)
+	}
+	n, _ := strconv.ParseUint(wp.Register[1:], 16, 4)
+	return int(regs.V[n])
+}
+
+// check returns a description of what triggered the watchpoint, or an
+// empty string if it did not trigger.
+func (wp *Watchpoint) check(before, after *chip8.Registers, accesses []access, memory []byte) string {
+	if wp.Register != "" {
+		old, cur := wp.register(before), wp.register(after)
+		if old == cur || !wp.test(cur) {
+			return ""
+		}
+		return fmt.Sprintf("%s $%02X -> $%02X", wp.Register, old, cur)
+	}
+
+	for _, a := range accesses {
+		if a.addr < wp.Start || a.addr > wp.End {
+			continue
+		}
+
+		kind, verb := Read, "read"
+		if a.write {
+			kind, verb = Write, "write"
+		}
+		if wp.Access&kind != 0 && wp.test(int(memory[a.addr])) {
+			return fmt.Sprintf("%s $%03X = $%02X", verb, a.addr, memory[a.addr])
+		}
+	}
+	return ""
+}
+
+func (wp *Watchpoint) String() string {
+	return fmt.Sprintf("%s (%d hits)", wp.Spec, wp.Hits)
+}
+
+// AddWatchpoint adds a watchpoint.
+func (d *Debugger) AddWatchpoint(wp *Watchpoint) {
+	d.watchpoints = append(d.watchpoints, wp)
+}
+
+// Watchpoints returns the watchpoints, in the order they were added.
+func (d *Debugger) Watchpoints() []*Watchpoint {
+	return d.watchpoints
+}
+
+// ClearWatchpoints removes all watchpoints.
+func (d *Debugger) ClearWatchpoints() {
+	d.watchpoints = nil
+}
+
+func (d *Debugger) access(addr uint16, write bool) {
+	if write {
+		d.recordWrite(addr)
+	}
+	if len(d.watchpoints) > 0 {
+		d.accesses = append(d.accesses, access{addr, write})
+	}
+}
+
+// checkWatchpoints returns a *Break for the first watchpoint triggered by
+// the instruction at pc.
+func (d *Debugger) checkWatchpoints(pc uint16, before *chip8.Registers) error {
+	var brk *Break
+	after := d.System.Registers
+
+	for _, wp := range d.watchpoints {
+		what := wp.check(before, &after, d.accesses, d.System.Memory[:])
+		if what == "" {
+			continue
+		}
+
+		wp.Hits++
+		if wp.Hits >= wp.After && brk == nil {
+			brk = &Break{pc, fmt.Sprintf("watchpoint %s (hit %d): %s", wp.Spec, wp.Hits, what)}
+		}
+	}
+
+	if brk == nil {
+		return nil
+	}
+	return brk
+}
@@ -0,0 +1,61 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package debugger
+
+import (
+	"testing"
+
+	"github.com/andreas-jonsson/chip8studio/emulator"
+)
+
+func TestTimerWatchpoint(t *testing.T) {
+	// load v0 2, loadd v0, loop: jump loop
+	d := newDebugger(0x6002, 0xF015, 0x1204)
+	wp, err := ParseWatchpoint("dt == 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.AddWatchpoint(wp)
+
+	sched := &emulator.Scheduler{
+		System:         d.System,
+		Exec:           d.Step,
+		Tick:           d.Tick,
+		CyclesPerFrame: 4,
+	}
+	if err := sched.Frame(); err != nil {
+		t.Fatalf("first frame: %v", err)
+	}
+	if d.System.DT != 1 {
+		t.Fatalf("dt = %d after the first frame, want 1", d.System.DT)
+	}
+
+	err = sched.Frame()
+	brk, ok := err.(*Break)
+	if !ok {
+		t.Fatalf("second frame: got %v, want a watchpoint break", err)
+	}
+	if brk.PC != 0x204 || d.System.DT != 0 || d.System.Frames != 2 || wp.Hits != 1 {
+		t.Errorf("break at $%03X with dt = %d after %d frames, want $204, 0, 2", brk.PC, d.System.DT, d.System.Frames)
+	}
+
+	// The timer stays at zero, so the watchpoint does not fire again.
+	if err := sched.Frame(); err != nil {
+		t.Errorf("third frame: %v", err)
+	}
+}
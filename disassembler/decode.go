@@ -0,0 +1,206 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package disassembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Flow int
+
+const (
+	FlowNext Flow = iota
+	FlowSkip
+	FlowJump
+	FlowCall
+	FlowIndirect
+	FlowReturn
+	FlowHalt
+)
+
+type Instruction struct {
+	Opcode   uint16
+	Mnemonic string
+	Operands []string
+	Flow     Flow
+
+	// Target is the address operand of sys, jump, call, loadi and jump0.
+	Target    uint16
+	HasTarget bool
+}
+
+func reg(n uint16) string {
+	return fmt.Sprintf("v%x", n&0xF)
+}
+
+func imm(n uint16) string {
+	return fmt.Sprintf("$%02x", n)
+}
+
+func nibble(n uint16) string {
+	return fmt.Sprint(n)
+}
+
+// Decode returns the instruction for op. It fails for opcodes that have
+// no mnemonic, or that the assembler could not produce bit for bit.
+func Decode(op uint16) (Instruction, bool) {
+	var (
+		x   = (op >> 8) & 0xF
+		y   = (op >> 4) & 0xF
+		n   = op & 0xF
+		nn  = op & 0xFF
+		nnn = op & 0xFFF
+	)
+
+	inst := Instruction{Opcode: op}
+	set := func(mnemonic string, operands ...string) {
+		inst.Mnemonic = mnemonic
+		inst.Operands = operands
+	}
+	target := func(mnemonic string, flow Flow) {
+		set(mnemonic, fmt.Sprintf("$%03x", nnn))
+		inst.Flow = flow
+		inst.Target = nnn
+		inst.HasTarget = true
+	}
+
+	switch op >> 12 {
+	case 0x0:
+		switch {
+		case op == 0x00E0:
+			set("clr")
+		case op == 0x00EE:
+			set("rts")
+			inst.Flow = FlowReturn
+		case op&0xFFF0 == 0x00C0:
+			set("scr", nibble(n))
+		case op == 0x00FB:
+			set("scrr")
+		case op == 0x00FC:
+			set("scrl")
+		case op == 0x00FD:
+			set("halt")
+			inst.Flow = FlowHalt
+		case op == 0x00FE:
+			set("low")
+		case op == 0x00FF:
+			set("high")
+		default:
+			target("sys", FlowNext)
+		}
+	case 0x1:
+		target("jump", FlowJump)
+	case 0x2:
+		target("call", FlowCall)
+	case 0x3:
+		set("ske", reg(x), imm(nn))
+		inst.Flow = FlowSkip
+	case 0x4:
+		set("skne", reg(x), imm(nn))
+		inst.Flow = FlowSkip
+	case 0x5:
+		if n != 0 {
+			return inst, false
+		}
+		set("skre", reg(x), reg(y))
+		inst.Flow = FlowSkip
+	case 0x6:
+		set("load", reg(x), imm(nn))
+	case 0x7:
+		set("add", reg(x), imm(nn))
+	case 0x8:
+		mnemonic := [16]string{0: "move", 1: "or", 2: "and", 3: "xor", 4: "addr", 5: "sub", 6: "shr", 7: "subr", 0xE: "shl"}[n]
+		switch {
+		case mnemonic == "":
+			return inst, false
+		case n == 6 || n == 0xE:
+			if y != 0 {
+				return inst, false
+			}
+			set(mnemonic, reg(x))
+		default:
+			set(mnemonic, reg(x), reg(y))
+		}
+	case 0x9:
+		if n != 0 {
+			return inst, false
+		}
+		set("sknre", reg(x), reg(y))
+		inst.Flow = FlowSkip
+	case 0xA:
+		target("loadi", FlowNext)
+	case 0xB:
+		target("jump0", FlowIndirect)
+	case 0xC:
+		set("rand", reg(x), imm(nn))
+	case 0xD:
+		set("draw", reg(x), reg(y), nibble(n))
+	case 0xE:
+		switch nn {
+		case 0x9E:
+			set("skp", reg(x))
+		case 0xA1:
+			set("sknp", reg(x))
+		default:
+			return inst, false
+		}
+		inst.Flow = FlowSkip
+	case 0xF:
+		mnemonic, ok := map[uint16]string{
+			0x07: "moved",
+			0x0A: "keyd",
+			0x15: "loadd",
+			0x18: "loads",
+			0x1E: "addi",
+			0x29: "ldspr",
+			0x30: "ldhspr",
+			0x33: "bcd",
+			0x55: "stor",
+			0x65: "read",
+			0x75: "storr",
+			0x85: "readr",
+		}[nn]
+		if !ok {
+			return inst, false
+		}
+		set(mnemonic, reg(x))
+	}
+
+	return inst, true
+}
+
+// Format returns the instruction as assembler source. If symbol returns a
+// name for the target address, the name is used instead of the number.
+func (inst Instruction) Format(symbol func(addr uint16) (string, bool)) string {
+	operands := inst.Operands
+	if inst.HasTarget && symbol != nil {
+		if name, ok := symbol(inst.Target); ok {
+			operands = []string{name}
+		}
+	}
+
+	if len(operands) == 0 {
+		return inst.Mnemonic
+	}
+	return fmt.Sprintf("%-8s%s", inst.Mnemonic, strings.Join(operands, " "))
+}
+
+func (inst Instruction) String() string {
+	return inst.Format(nil)
+}
@@ -0,0 +1,180 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package disassembler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	ProgramStart = 0x200
+	maxDataLine  = 8
+)
+
+type lableKind int
+
+const (
+	dataLable lableKind = iota
+	tableLable
+	jumpLable
+	subLable
+)
+
+var lablePrefix = [...]string{
+	dataLable:  "Data",
+	tableLable: "Table",
+	jumpLable:  "Lable",
+	subLable:   "Sub",
+}
+
+type disassembly struct {
+	program []byte
+	code    []bool
+	lables  map[uint16]lableKind
+}
+
+func (d *disassembly) addLable(addr uint16, kind lableKind) {
+	if addr < ProgramStart || int(addr-ProgramStart) > len(d.program) {
+		return
+	}
+	if k, ok := d.lables[addr]; !ok || kind > k {
+		d.lables[addr] = kind
+	}
+}
+
+func (d *disassembly) lable(addr uint16) (string, bool) {
+	kind, ok := d.lables[addr]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%s_%03X", lablePrefix[kind], addr), true
+}
+
+func (d *disassembly) decode(addr uint16) (Instruction, bool) {
+	offset := int(addr) - ProgramStart
+	if offset < 0 || offset+2 > len(d.program) {
+		return Instruction{}, false
+	}
+	return Decode(uint16(d.program[offset])<<8 | uint16(d.program[offset+1]))
+}
+
+// trace follows every reachable path from the program start and marks
+// the instructions found on the way as code.
+func (d *disassembly) trace() {
+	work := []uint16{ProgramStart}
+
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		for {
+			inst, ok := d.decode(addr)
+			if !ok || d.code[addr-ProgramStart] {
+				break
+			}
+			d.code[addr-ProgramStart] = true
+
+			next := addr + 2
+			switch inst.Flow {
+			case FlowJump:
+				d.addLable(inst.Target, jumpLable)
+				work = append(work, inst.Target)
+			case FlowCall:
+				d.addLable(inst.Target, subLable)
+				work = append(work, inst.Target, next)
+			case FlowIndirect:
+				d.addLable(inst.Target, tableLable)
+			case FlowSkip:
+				work = append(work, next, next+2)
+			case FlowNext:
+				if inst.Mnemonic == "loadi" {
+					d.addLable(inst.Target, dataLable)
+				}
+				addr = next
+				continue
+			}
+			break
+		}
+	}
+}
+
+func (d *disassembly) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	end := ProgramStart + len(d.program)
+
+	for addr := ProgramStart; addr < end; {
+		if name, ok := d.lable(uint16(addr)); ok {
+			fmt.Fprintf(bw, "\n%s:\n", name)
+		}
+
+		offset := addr - ProgramStart
+		if d.isCode(addr) {
+			inst, _ := d.decode(uint16(addr))
+			fmt.Fprintf(bw, "    %s\n", inst.Format(d.lable))
+			addr += 2
+			continue
+		}
+
+		var data []string
+		for len(data) < maxDataLine && addr < end {
+			data = append(data, fmt.Sprintf("$%02x", d.program[offset+len(data)]))
+			addr++
+
+			if _, ok := d.lables[uint16(addr)]; ok || d.isCode(addr) {
+				break
+			}
+		}
+		fmt.Fprintf(bw, "    %-8s%s\n", ".", strings.Join(data, " "))
+	}
+
+	if name, ok := d.lable(uint16(end)); ok {
+		fmt.Fprintf(bw, "\n%s:\n", name)
+	}
+	return bw.Flush()
+}
+
+// isCode returns true if an instruction can be emitted at addr without
+// hiding a lable or another instruction in its second byte.
+func (d *disassembly) isCode(addr int) bool {
+	offset := addr - ProgramStart
+	if offset < 0 || offset+2 > len(d.program) || !d.code[offset] {
+		return false
+	}
+	if _, ok := d.lables[uint16(addr+1)]; ok {
+		return false
+	}
+	return !d.code[offset+1]
+}
+
+// Disassemble writes assembler source for program, loaded at $200, to w.
+// Reachable code is found by tracing control flow from the program start,
+// everything else is written as data. Assembling the output reproduces
+// program byte for byte.
+func Disassemble(program []byte, w io.Writer) error {
+	d := &disassembly{
+		program: program,
+		code:    make([]bool, len(program)),
+		lables:  make(map[uint16]lableKind),
+	}
+
+	d.trace()
+	return d.write(w)
+}
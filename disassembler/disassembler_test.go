@@ -0,0 +1,161 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package disassembler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andreas-jonsson/chip8studio/assembler"
+	"github.com/andreas-jonsson/chip8studio/example"
+)
+
+func init() {
+	assembler.Logger = log.New(ioutil.Discard, "", 0)
+}
+
+func assemble(t *testing.T, source string) []byte {
+	t.Helper()
+
+	fp, err := ioutil.TempFile("", "disassembler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	defer fp.Close()
+
+	_, diags := assembler.Assemble("test.asm", strings.NewReader(source), fp, assembler.Options{})
+	if assembler.HasErrors(diags) {
+		t.Fatalf("%v\n%s", diags, source)
+	}
+
+	prog, err := ioutil.ReadFile(fp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+// roundTrip disassembles program and assembles the result, which must give
+// back program.
+func roundTrip(t *testing.T, name string, program []byte) {
+	t.Helper()
+
+	var source bytes.Buffer
+	if err := Disassemble(program, &source); err != nil {
+		t.Fatal(err)
+	}
+	if prog := assemble(t, source.String()); !bytes.Equal(prog, program) {
+		t.Errorf("%s: assembled disassembly differs\ngot  % X\nwant % X\n%s", name, prog, program, source.String())
+	}
+}
+
+// TestDecode assembles every instruction Decode accepts, and checks that it
+// gives back the opcode.
+func TestDecode(t *testing.T) {
+	const batch = 1024
+
+	var (
+		source  []string
+		opcodes []byte
+	)
+	flush := func() {
+		prog := assemble(t, strings.Join(source, "\n"))
+		if !bytes.Equal(prog, opcodes) {
+			for i := 0; i+1 < len(prog) && i+1 < len(opcodes); i += 2 {
+				if prog[i] != opcodes[i] || prog[i+1] != opcodes[i+1] {
+					t.Fatalf("%s assembled to $%02X%02X, want $%02X%02X", source[i/2], prog[i], prog[i+1], opcodes[i], opcodes[i+1])
+				}
+			}
+			t.Fatalf("got %d bytes, want %d", len(prog), len(opcodes))
+		}
+		source, opcodes = source[:0], opcodes[:0]
+	}
+
+	decoded := 0
+	for op := 0; op <= 0xFFFF; op++ {
+		inst, ok := Decode(uint16(op))
+		if !ok {
+			continue
+		}
+		if inst.Opcode != uint16(op) {
+			t.Fatalf("Decode($%04X) has opcode $%04X", op, inst.Opcode)
+		}
+
+		decoded++
+		source = append(source, inst.String())
+		opcodes = append(opcodes, byte(op>>8), byte(op))
+		if len(source) == batch {
+			flush()
+		}
+	}
+	flush()
+
+	// Every opcode of groups 0-4, 6, 7 and A-D, 256 of each of 5 and 9,
+	// 7*256+2*16 of 8, 2*16 of E and 12*16 of F.
+	if want := 11*4096 + 2*256 + 7*256 + 2*16 + 2*16 + 12*16; decoded != want {
+		t.Errorf("%d opcodes decoded, want %d", decoded, want)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, op := range []uint16{0x5121, 0x8128, 0x8116, 0x912F, 0xE19F, 0xF1FF, 0xF000} {
+		if inst, ok := Decode(op); ok {
+			t.Errorf("Decode($%04X) = %s, want invalid", op, inst)
+		}
+	}
+}
+
+func TestDisassemblePong(t *testing.T) {
+	roundTrip(t, "pong", assemble(t, example.Pong))
+}
+
+func TestDisassembleData(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+	}{
+		{"empty", nil},
+		{"odd length", []byte{0x60, 0x01, 0x12}},
+		{"invalid opcodes", []byte{0xF1, 0xFF, 0x81, 0x28, 0x12, 0x00}},
+		// loadi points into the second byte of a jump, which must then be
+		// written as data to keep the lable.
+		{"lable in instruction", []byte{0xA2, 0x03, 0x12, 0x04, 0x12, 0x04}},
+		{"skip over data", []byte{0x30, 0x00, 0x12, 0x08, 0x00, 0xFD, 0xAB, 0xCD, 0x22, 0x0C, 0x00, 0xFD, 0x00, 0xEE}},
+		{"jump to end", []byte{0x12, 0x02}},
+		{"jump table", []byte{0xB2, 0x04, 0x00, 0xFD, 0x12, 0x08, 0x12, 0x08, 0x12, 0x08}},
+	}
+
+	for _, test := range tests {
+		roundTrip(t, test.name, test.program)
+	}
+}
+
+func TestDisassembleRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		program := make([]byte, 1+rnd.Intn(512))
+		rnd.Read(program)
+		roundTrip(t, "random", program)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/nfnt/resize"
+
+	"github.com/aarzilli/nucular"
+
+	"github.com/andreas-jonsson/chip8studio/display"
+)
+
+// nucularDisplay draws frames in a nucular window, scaled to fit and
+// centered. Only whole pixel scales are used if the window is large enough,
+// so all pixels get the same size.
+type nucularDisplay struct {
+	// Window is the window drawn in. Frames are only drawn while it is
+	// being updated.
+	Window *nucular.Window
+
+	palette    color.Palette
+	backBuffer *image.RGBA
+}
+
+func newNucularDisplay() *nucularDisplay {
+	return &nucularDisplay{
+		palette:    display.DefaultPalette,
+		backBuffer: image.NewRGBA(image.Rect(0, 0, 64, 32)),
+	}
+}
+
+func (d *nucularDisplay) Resize(width, height int) {
+	if d.backBuffer.Rect.Dx() != width || d.backBuffer.Rect.Dy() != height {
+		d.backBuffer = image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+}
+
+func (d *nucularDisplay) SetPalette(p color.Palette) {
+	d.palette = p
+}
+
+func (d *nucularDisplay) Draw(video []byte) {
+	pix := d.backBuffer.Pix
+	for i, p := range video {
+		r, g, b, _ := d.palette[int(p)%len(d.palette)].RGBA()
+		pix[i*4] = byte(r >> 8)
+		pix[i*4+1] = byte(g >> 8)
+		pix[i*4+2] = byte(b >> 8)
+		pix[i*4+3] = 0xFF
+	}
+
+	w := d.Window
+	if w == nil {
+		return
+	}
+
+	width, height := d.backBuffer.Rect.Dx(), d.backBuffer.Rect.Dy()
+	availW, availH := w.LayoutAvailableWidth()-15, w.LayoutAvailableHeight()-15
+	scale := math.Min(float64(availW)/float64(width), float64(availH)/float64(height))
+	if scale >= 1 {
+		scale = math.Floor(scale)
+	}
+	imgW, imgH := int(float64(width)*scale), int(float64(height)*scale)
+	if imgW < 1 || imgH < 1 {
+		return
+	}
+
+	w.Row(imgH).Static((availW-imgW)/2, imgW)
+	w.Spacing(1)
+	w.Image(resize.Resize(uint(imgW), uint(imgH), d.backBuffer, resize.NearestNeighbor).(*image.RGBA))
+}
@@ -0,0 +1,81 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package display shows the framebuffer of the emulator, in memory or in a
+// terminal. The studio draws its window with its own Display, in display.go
+// of the main package.
+package display
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"math"
+)
+
+// DefaultPalette is the palette the Chippy color system calls index.
+var DefaultPalette color.Palette = palette.Plan9
+
+// Display shows frames of the emulator. A frame is one palette index per
+// pixel, row by row.
+type Display interface {
+	// Resize is called when the video mode changes, before the first frame
+	// of the new size.
+	Resize(width, height int)
+
+	// SetPalette sets the colors of the palette indexes.
+	SetPalette(p color.Palette)
+
+	// Draw shows a frame of the size last set by Resize.
+	Draw(video []byte)
+}
+
+// Size returns the width and height of a frame of n pixels. The display is
+// always twice as wide as it is high.
+func Size(n int) (width, height int) {
+	width = int(math.Sqrt(float64(n * 2)))
+	return width, width / 2
+}
+
+// Image keeps the last frame drawn in memory, as for tests.
+type Image struct {
+	*image.Paletted
+}
+
+// NewImage returns an empty 64x32 image.
+func NewImage() *Image {
+	return &Image{image.NewPaletted(image.Rect(0, 0, 64, 32), DefaultPalette)}
+}
+
+func (m *Image) Resize(width, height int) {
+	m.Paletted = image.NewPaletted(image.Rect(0, 0, width, height), m.Palette)
+}
+
+func (m *Image) SetPalette(p color.Palette) {
+	m.Palette = p
+}
+
+func (m *Image) Draw(video []byte) {
+	copy(m.Pix, video)
+}
+
+// Frame returns a copy of the last frame.
+func (m *Image) Frame() *image.Paletted {
+	img := *m.Paletted
+	img.Pix = append([]byte(nil), m.Pix...)
+	return &img
+}
@@ -0,0 +1,118 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package display
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"io"
+	"strings"
+)
+
+// Terminal draws frames in a terminal with ANSI escape codes and 24 bit
+// colors. Every character is two pixels, one above the other, drawn with
+// the upper half block.
+type Terminal struct {
+	// Row and Col is the position of the top left corner on the screen,
+	// starting at 1.
+	Row, Col int
+
+	w       io.Writer
+	palette color.Palette
+	width   int
+	height  int
+
+	// Size of the previous mode, to erase what is left of it.
+	clearWidth  int
+	clearHeight int
+}
+
+// NewTerminal returns a 64x32 display in the top left corner of the
+// terminal written to w.
+func NewTerminal(w io.Writer) *Terminal {
+	return &Terminal{Row: 1, Col: 1, w: w, palette: DefaultPalette, width: 64, height: 32}
+}
+
+// Lines returns the number of lines the display takes in the terminal.
+func (t *Terminal) Lines() int {
+	return (t.height + 1) / 2
+}
+
+// Columns returns the number of columns the display takes in the terminal.
+func (t *Terminal) Columns() int {
+	return t.width
+}
+
+func (t *Terminal) Resize(width, height int) {
+	if width < t.width || height < t.height {
+		t.clearWidth, t.clearHeight = t.width, t.height
+	}
+	t.width, t.height = width, height
+}
+
+func (t *Terminal) SetPalette(p color.Palette) {
+	t.palette = p
+}
+
+func (t *Terminal) Draw(video []byte) {
+	var buf bytes.Buffer
+
+	if t.clearWidth > 0 {
+		blank := strings.Repeat(" ", t.clearWidth)
+		for y := 0; y < (t.clearHeight+1)/2; y++ {
+			fmt.Fprintf(&buf, "\x1b[%d;%dH\x1b[0m%s", t.Row+y, t.Col, blank)
+		}
+		t.clearWidth, t.clearHeight = 0, 0
+	}
+
+	pixel := func(x, y int) color.Color {
+		if i := y*t.width + x; y < t.height && i < len(video) {
+			return t.palette[int(video[i])%len(t.palette)]
+		}
+		return color.Black
+	}
+
+	for y := 0; y < t.height; y += 2 {
+		fmt.Fprintf(&buf, "\x1b[%d;%dH", t.Row+y/2, t.Col)
+
+		var fg, bg color.Color
+		for x := 0; x < t.width; x++ {
+			upper, lower := pixel(x, y), pixel(x, y+1)
+			if upper != fg {
+				r, g, b := rgb(upper)
+				fmt.Fprintf(&buf, "\x1b[38;2;%d;%d;%dm", r, g, b)
+				fg = upper
+			}
+			if lower != bg {
+				r, g, b := rgb(lower)
+				fmt.Fprintf(&buf, "\x1b[48;2;%d;%d;%dm", r, g, b)
+				bg = lower
+			}
+			buf.WriteString("▀")
+		}
+		buf.WriteString("\x1b[0m")
+	}
+
+	t.w.Write(buf.Bytes())
+}
+
+func rgb(c color.Color) (r, g, b uint8) {
+	r32, g32, b32, _ := c.RGBA()
+	return uint8(r32 >> 8), uint8(g32 >> 8), uint8(b32 >> 8)
+}
@@ -18,17 +18,12 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package emulator
 
 import (
-	"image"
-	"image/color/palette"
 	"math/rand"
 	"sync"
 	"time"
 
-	"github.com/nfnt/resize"
-
-	"golang.org/x/mobile/event/key"
-
-	"github.com/aarzilli/nucular"
+	"github.com/andreas-jonsson/chip8studio/audio"
+	"github.com/andreas-jonsson/chip8studio/display"
 )
 
 const DefaultCPUSpeed = 500
@@ -37,11 +32,12 @@ type Machine struct {
 	sync.Mutex
 
 	Program    []byte
-	Window     *nucular.Window
+	Display    display.Display
 	CpuSpeedHz time.Duration
-	Event      *key.Event
+	Keypad     Keypad
+	Tone       *audio.Tone
 
-	backBuffer *image.RGBA
+	width int
 }
 
 func (m *Machine) Load(memory []byte) {
@@ -53,74 +49,43 @@ func (m *Machine) Rand() *rand.Rand {
 }
 
 func (m *Machine) BeginTone() {
+	if m.Tone != nil {
+		m.Tone.Start()
+	}
 }
 
 func (m *Machine) EndTone() {
+	if m.Tone != nil {
+		m.Tone.Stop()
+	}
 }
 
-func (m *Machine) Key(code int) bool {
-	if m.Event != nil {
-		switch key.Code(m.Event.Code) {
-		case key.Code0, key.CodeKeypad0:
-			return code == 0
-		case key.Code1, key.CodeKeypad1:
-			return code == 1
-		case key.Code2, key.CodeKeypad2:
-			return code == 2
-		case key.Code3, key.CodeKeypad3:
-			return code == 3
-		case key.Code4, key.CodeKeypad4:
-			return code == 4
-		case key.Code5, key.CodeKeypad5:
-			return code == 5
-		case key.Code6, key.CodeKeypad6:
-			return code == 6
-		case key.Code7, key.CodeKeypad7:
-			return code == 7
-		case key.Code8, key.CodeKeypad8:
-			return code == 8
-		case key.Code9, key.CodeKeypad9:
-			return code == 9
-		case key.CodeA:
-			return code == 0xA
-		case key.CodeB:
-			return code == 0xB
-		case key.CodeC:
-			return code == 0xC
-		case key.CodeD:
-			return code == 0xD
-		case key.CodeE:
-			return code == 0xE
-		case key.CodeF:
-			return code == 0xF
-		}
+func (m *Machine) SetAudioPattern(pattern [16]byte, pitch byte, ok bool) {
+	if m.Tone != nil {
+		m.Tone.SetPattern(pattern, pitch, ok)
 	}
+}
 
-	return false
+func (m *Machine) Key(code int) bool {
+	return m.Keypad.Pressed(code)
 }
+
 func (m *Machine) SetCPUFrequency(freq int) {
 	m.CpuSpeedHz = time.Duration(freq)
 }
 
 func (m *Machine) ResizeVideo(width int) {
+	m.width = width
+	m.Display.Resize(width, width/2)
 }
 
 func (m *Machine) Draw(video []byte) {
-	if m.backBuffer == nil {
-		m.backBuffer = image.NewRGBA(image.Rect(0, 0, 64, 32))
+	// The size is taken from the video itself, so a frame is never drawn
+	// with the size of another mode, as when the mode changes by stepping
+	// back in history.
+	if width, height := display.Size(len(video)); width != m.width {
+		m.width = width
+		m.Display.Resize(width, height)
 	}
-
-	pix := m.backBuffer.Pix
-	for i, p := range video {
-		r, g, b, _ := palette.Plan9[p].RGBA()
-		pix[i*4] = byte(r)
-		pix[i*4+1] = byte(g)
-		pix[i*4+2] = byte(b)
-		pix[i*4+3] = 0xFF
-	}
-
-	w := m.Window
-	bounds := w.Bounds
-	w.Row(bounds.H).Static(bounds.W)
-	w.Image(resize.Resize(uint(bounds.W-15), 0, m.backBuffer, resize.NearestNeighbor).(*image.RGBA))
+	m.Display.Draw(video)
 }
@@ -0,0 +1,162 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package headless is a machine for running programs without a window, as
+// in automated tests.
+package headless
+
+import (
+	"image"
+	"math/rand"
+
+	"github.com/andreas-jonsson/chip8studio/audio"
+	"github.com/andreas-jonsson/chip8studio/chip8"
+	"github.com/andreas-jonsson/chip8studio/display"
+	"github.com/andreas-jonsson/chip8studio/emulator"
+)
+
+// Machine runs a program without a window. Keys are pressed by setting
+// Keys, the display is drawn to Display and the sound is written to Sink.
+type Machine struct {
+	Program []byte
+
+	// Seed seeds the random numbers of the program, so every run of a
+	// program gives the same result.
+	Seed int64
+
+	// Keys holds the keys that are pressed.
+	Keys [16]bool
+
+	// CPUFrequency is the speed in instructions per second, as last set by
+	// the program.
+	CPUFrequency int
+
+	// Tone generates the sound of the machine, which Run writes to Sink
+	// as the program runs, at the sample rate of the tone.
+	Tone *audio.Tone
+	Sink audio.Sink
+
+	Display *display.Image
+
+	// samples counts the samples written since reset.
+	samples uint64
+}
+
+// New returns a machine running program at emulator.DefaultCPUSpeed, with
+// the sound going to audio.Null.
+func New(program []byte) *Machine {
+	return &Machine{
+		Program:      program,
+		CPUFrequency: emulator.DefaultCPUSpeed,
+		Tone:         audio.NewTone(),
+		Sink:         audio.Null{},
+		Display:      display.NewImage(),
+	}
+}
+
+func (m *Machine) Load(memory []byte) {
+	copy(memory, m.Program)
+	m.samples = 0
+}
+
+func (m *Machine) Rand() *rand.Rand {
+	return rand.New(rand.NewSource(m.Seed))
+}
+
+func (m *Machine) BeginTone() {
+	m.Tone.Start()
+}
+
+func (m *Machine) EndTone() {
+	m.Tone.Stop()
+}
+
+func (m *Machine) SetAudioPattern(pattern [16]byte, pitch byte, ok bool) {
+	m.Tone.SetPattern(pattern, pitch, ok)
+}
+
+func (m *Machine) Key(code int) bool {
+	return m.Keys[code&0xF]
+}
+
+func (m *Machine) SetCPUFrequency(freq int) {
+	m.CPUFrequency = freq
+}
+
+func (m *Machine) ResizeVideo(width int) {
+	m.Display.Resize(width, width/2)
+}
+
+func (m *Machine) Draw(video []byte) {
+	if width, height := display.Size(len(video)); width != m.Display.Rect.Dx() {
+		m.Display.Resize(width, height)
+	}
+	m.Display.Draw(video)
+}
+
+// Image returns the display as last drawn.
+func (m *Machine) Image() *image.Paletted {
+	return m.Display.Frame()
+}
+
+// writeSound writes the tone to Sink up to the time of the instruction the
+// scheduler is at. Time is counted in frames and instructions, so the
+// sound is the same however fast the program runs.
+func (m *Machine) writeSound(sched *emulator.Scheduler) error {
+	cycles := uint64(sched.Cycles())
+	elapsed := sched.System.Frames*cycles + uint64(sched.Cycle())
+	due := elapsed * uint64(m.Tone.SampleRate) / (chip8.FrameRate * cycles)
+	if due <= m.samples {
+		return nil
+	}
+
+	samples := make([]int16, due-m.samples)
+	m.Tone.Generate(samples)
+	m.samples = due
+	return m.Sink.Write(samples)
+}
+
+// Run executes instructions with sched, which must run a system on m,
+// until the program has run for frames frames, or cycles instructions,
+// whichever comes first. A limit of zero is no limit. Before every frame,
+// keys is called with the frame number, if it is set, to let it press
+// keys. The sound is written to Sink as the program runs. It returns the
+// number of instructions executed.
+func (m *Machine) Run(sched *emulator.Scheduler, frames uint64, cycles int, keys func(frame uint64)) (int, error) {
+	sys := sched.System
+	frame := ^uint64(0)
+	n := 0
+	for (frames == 0 || sys.Frames < frames) && (cycles == 0 || n < cycles) && !sys.Halted {
+		if keys != nil && sys.Frames != frame {
+			frame = sys.Frames
+			keys(frame)
+		}
+
+		if err := sched.Step(); err != nil {
+			return n, err
+		}
+		n++
+
+		if err := m.writeSound(sched); err != nil {
+			return n, err
+		}
+	}
+
+	sys.Invalidate()
+	sys.Refresh()
+	return n, nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package headless
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/andreas-jonsson/chip8studio/chip8"
+	"github.com/andreas-jonsson/chip8studio/emulator"
+)
+
+// newScheduler returns a machine running the opcodes at 10 instructions
+// per frame, and its scheduler.
+func newScheduler(opcodes ...uint16) (*Machine, *emulator.Scheduler) {
+	var program []byte
+	for _, op := range opcodes {
+		program = append(program, byte(op>>8), byte(op))
+	}
+	m := New(program)
+	return m, &emulator.Scheduler{System: chip8.NewSystem(m), CyclesPerFrame: 10}
+}
+
+func TestRunLimits(t *testing.T) {
+	tests := []struct {
+		frames uint64
+		cycles int
+		n      int
+		frame  uint64
+	}{
+		{3, 0, 30, 3},
+		{0, 25, 25, 2},
+		{3, 25, 25, 2},
+		{2, 100, 20, 2},
+	}
+
+	for _, tt := range tests {
+		// jump $200
+		m, sched := newScheduler(0x1200)
+		var keyFrames []uint64
+		n, err := m.Run(sched, tt.frames, tt.cycles, func(frame uint64) {
+			keyFrames = append(keyFrames, frame)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != tt.n || sched.System.Frames != tt.frame {
+			t.Errorf("frames %d, cycles %d: ran %d instructions and %d frames, want %d and %d", tt.frames, tt.cycles, n, sched.System.Frames, tt.n, tt.frame)
+		}
+
+		// Keys are set once at the start of every frame that runs.
+		var want []uint64
+		for frame := uint64(0); len(want)*10 < tt.n; frame++ {
+			want = append(want, frame)
+		}
+		if !reflect.DeepEqual(keyFrames, want) {
+			t.Errorf("frames %d, cycles %d: keys set in frames %v, want %v", tt.frames, tt.cycles, keyFrames, want)
+		}
+	}
+}
+
+func TestRunHalt(t *testing.T) {
+	// load v0 1, exit
+	m, sched := newScheduler(0x6001, 0x00FD)
+	n, err := m.Run(sched, 10, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || !sched.System.Halted {
+		t.Errorf("ran %d instructions, halted %v, want 2, true", n, sched.System.Halted)
+	}
+}
+
+func TestRunError(t *testing.T) {
+	// load v0 1, sys $FFF
+	m, sched := newScheduler(0x6001, 0x0FFF)
+	n, err := m.Run(sched, 10, 0, nil)
+	if err == nil || n != 1 {
+		t.Errorf("ran %d instructions with error %v, want 1 and an error", n, err)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	// rand v0 $FF, rand v1 $FF, rand v2 $FF, rand v3 $FF, jump $208
+	random := func(seed int64) [4]byte {
+		m, sched := newScheduler(0xC0FF, 0xC1FF, 0xC2FF, 0xC3FF, 0x1208)
+		// The system takes its random numbers from the machine on reset.
+		m.Seed = seed
+		sched.System.Reset()
+		if _, err := m.Run(sched, 1, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+		var v [4]byte
+		copy(v[:], sched.System.V[:4])
+		return v
+	}
+
+	if a, b := random(1), random(1); a != b {
+		t.Errorf("seed 1 gave %v and %v", a, b)
+	}
+	if a, b := random(1), random(2); a == b {
+		t.Errorf("seeds 1 and 2 both gave %v", a)
+	}
+}
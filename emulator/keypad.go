@@ -0,0 +1,144 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package emulator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/mobile/event/key"
+)
+
+// Layout is the order of the CHIP-8 keys on the original hex keypad, row
+// by row.
+var Layout = [16]int{
+	0x1, 0x2, 0x3, 0xC,
+	0x4, 0x5, 0x6, 0xD,
+	0x7, 0x8, 0x9, 0xE,
+	0xA, 0x0, 0xB, 0xF,
+}
+
+// Keymap maps each of the 16 CHIP-8 keys to a key on the keyboard.
+type Keymap [16]key.Code
+
+// DefaultKeymap puts the hex keypad on the left side of the keyboard.
+var DefaultKeymap = Keymap{
+	0x1: key.Code1, 0x2: key.Code2, 0x3: key.Code3, 0xC: key.Code4,
+	0x4: key.CodeQ, 0x5: key.CodeW, 0x6: key.CodeE, 0xD: key.CodeR,
+	0x7: key.CodeA, 0x8: key.CodeS, 0x9: key.CodeD, 0xE: key.CodeF,
+	0xA: key.CodeZ, 0x0: key.CodeX, 0xB: key.CodeC, 0xF: key.CodeV,
+}
+
+var keyCodes = make(map[string]key.Code)
+
+func init() {
+	for c := key.Code(0); c <= key.CodeRightGUI; c++ {
+		if name := KeyName(c); !strings.HasPrefix(name, "(") {
+			keyCodes[strings.ToUpper(name)] = c
+		}
+	}
+}
+
+// KeyName returns the name of a keyboard key, like "Q" or "Keypad5".
+func KeyName(c key.Code) string {
+	return strings.TrimPrefix(c.String(), "Code")
+}
+
+// String formats the keymap as four lines of key names, in the layout of
+// the hex keypad.
+func (m Keymap) String() string {
+	var s string
+	for i, k := range Layout {
+		s += KeyName(m[k])
+		if i%4 == 3 {
+			s += "\n"
+		} else {
+			s += " "
+		}
+	}
+	return s
+}
+
+// ParseKeymap parses a keymap in the format written by String.
+func ParseKeymap(s string) (Keymap, error) {
+	var m Keymap
+	names := strings.Fields(s)
+	if len(names) != len(Layout) {
+		return m, fmt.Errorf("expected %d keys in keymap, found %d", len(Layout), len(names))
+	}
+
+	for i, name := range names {
+		c, ok := keyCodes[strings.ToUpper(name)]
+		if !ok {
+			return m, fmt.Errorf("unknown key %q in keymap", name)
+		}
+		m[Layout[i]] = c
+	}
+	return m, nil
+}
+
+// HoldTime is how long a key is held after it was pressed, while the key
+// has not been seen released. Some window systems only report presses,
+// repeated while the key is held down, for some or all keys.
+const HoldTime = 500 * time.Millisecond
+
+// Keypad is the pressed or released state of the 16 keys, updated from
+// keyboard events.
+type Keypad struct {
+	Map Keymap
+
+	pressed    [16]bool
+	pressTime  [16]time.Time
+	hasRelease [16]bool
+}
+
+// Event updates the state of the key mapped to the keyboard key of ev.
+// Events for keys that are not mapped are ignored.
+func (k *Keypad) Event(ev key.Event) {
+	for i, c := range k.Map {
+		if c != ev.Code {
+			continue
+		}
+
+		switch ev.Direction {
+		case key.DirRelease:
+			k.pressed[i] = false
+			k.hasRelease[i] = true
+		default:
+			k.pressed[i] = true
+			k.pressTime[i] = time.Now()
+		}
+	}
+}
+
+// Pressed returns true if the CHIP-8 key code is held down.
+func (k *Keypad) Pressed(code int) bool {
+	code &= 0xF
+	if k.pressed[code] && !k.hasRelease[code] && time.Since(k.pressTime[code]) > HoldTime {
+		k.pressed[code] = false
+	}
+	return k.pressed[code]
+}
+
+// Release releases all keys, as when the window loses focus or the keys
+// are mapped again.
+func (k *Keypad) Release() {
+	k.pressed = [16]bool{}
+	k.hasRelease = [16]bool{}
+}
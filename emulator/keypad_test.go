@@ -0,0 +1,74 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package emulator
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/mobile/event/key"
+)
+
+func press(k *Keypad, code key.Code, dir key.Direction) {
+	k.Event(key.Event{Code: code, Direction: dir})
+}
+
+func TestKeypadHold(t *testing.T) {
+	k := &Keypad{Map: DefaultKeymap}
+
+	// Key 1 reports releases, key 2 only presses.
+	press(k, key.Code1, key.DirPress)
+	press(k, key.Code1, key.DirRelease)
+	press(k, key.Code1, key.DirPress)
+	press(k, key.Code2, key.DirPress)
+	if !k.Pressed(1) || !k.Pressed(2) {
+		t.Fatal("pressed keys are released")
+	}
+
+	k.pressTime[1] = k.pressTime[1].Add(-2 * HoldTime)
+	k.pressTime[2] = k.pressTime[2].Add(-2 * HoldTime)
+	if !k.Pressed(1) {
+		t.Error("key 1 is released while held")
+	}
+	if k.Pressed(2) {
+		t.Error("key 2 is held after the hold time")
+	}
+
+	press(k, key.Code1, key.DirRelease)
+	if k.Pressed(1) {
+		t.Error("key 1 is held after it was released")
+	}
+}
+
+func TestKeypadRelease(t *testing.T) {
+	k := &Keypad{Map: DefaultKeymap}
+	press(k, key.Code1, key.DirRelease)
+	press(k, key.Code1, key.DirPress)
+
+	k.Release()
+	if k.Pressed(1) {
+		t.Fatal("key 1 is held after Release")
+	}
+
+	// Release forgets that the key reports releases.
+	press(k, key.Code1, key.DirPress)
+	k.pressTime[1] = time.Now().Add(-2 * HoldTime)
+	if k.Pressed(1) {
+		t.Error("key 1 is held after the hold time")
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package emulator
+
+import "github.com/andreas-jonsson/chip8studio/chip8"
+
+// Scheduler runs a system in frames of chip8.FrameRate Hz. Every frame runs
+// a fixed number of instructions and then ticks the timers once, so the
+// program runs the same no matter how the front end paces the frames.
+type Scheduler struct {
+	System *chip8.System
+
+	// Exec executes one instruction. It defaults to System.Step, and is
+	// set to the Step of a debugger to stop at breakpoints.
+	Exec func() error
+
+	// Tick ends a frame by running the timers. It defaults to System.Tick,
+	// and is set to the Tick of a debugger to stop at watchpoints on the
+	// timers.
+	Tick func() error
+
+	// Speed returns the speed of the machine in instructions per second,
+	// which gives the instructions per frame if CyclesPerFrame is zero.
+	Speed func() int
+
+	// CyclesPerFrame, if not zero, is the number of instructions per frame.
+	CyclesPerFrame int
+
+	cycle int
+}
+
+// Cycles returns the number of instructions run per frame.
+func (s *Scheduler) Cycles() int {
+	n := s.CyclesPerFrame
+	if n <= 0 && s.Speed != nil {
+		n = s.Speed() / chip8.FrameRate
+	}
+	if n <= 0 {
+		n = DefaultCPUSpeed / chip8.FrameRate
+	}
+	return n
+}
+
+// Cycle returns the number of instructions run in the current frame.
+func (s *Scheduler) Cycle() int {
+	return s.cycle
+}
+
+// Reset starts a new frame, as when the system is reset.
+func (s *Scheduler) Reset() {
+	s.cycle = 0
+}
+
+// Step executes one instruction, and ends the frame if it was the last
+// one. If the program waits for the next frame, the frame is ended first.
+// Instructions are only counted if Exec succeeds, as a breakpoint stops
+// before the instruction. An error from Tick is returned after the frame
+// has ended.
+func (s *Scheduler) Step() error {
+	sys := s.System
+	if sys.VBlankWait {
+		if err := s.endFrame(); err != nil {
+			return err
+		}
+	}
+
+	exec := s.Exec
+	if exec == nil {
+		exec = sys.Step
+	}
+	if err := exec(); err != nil {
+		return err
+	}
+
+	s.cycle++
+	if s.cycle >= s.Cycles() {
+		return s.endFrame()
+	}
+	return nil
+}
+
+// Frame runs the rest of the current frame. It stops early, without ending
+// the frame, if Exec fails, and early at the end of the frame if the
+// program waits for the next frame.
+func (s *Scheduler) Frame() error {
+	sys := s.System
+	frame := sys.Frames
+	for sys.Frames == frame {
+		if sys.VBlankWait || sys.Halted {
+			return s.endFrame()
+		}
+		if err := s.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) endFrame() error {
+	s.cycle = 0
+	if s.Tick == nil {
+		s.System.Tick()
+		return nil
+	}
+	return s.Tick()
+}
@@ -0,0 +1,192 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package emulator
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/andreas-jonsson/chip8studio/chip8"
+)
+
+// testMachine runs a program given as opcodes.
+type testMachine struct {
+	program []byte
+}
+
+func (m *testMachine) Load(memory []byte)  { copy(memory, m.program) }
+func (m *testMachine) Rand() *rand.Rand    { return rand.New(rand.NewSource(1)) }
+func (m *testMachine) BeginTone()          {}
+func (m *testMachine) EndTone()            {}
+func (m *testMachine) Key(code int) bool   { return false }
+func (m *testMachine) SetCPUFrequency(int) {}
+func (m *testMachine) ResizeVideo(int)     {}
+func (m *testMachine) Draw(video []byte)   {}
+
+// newScheduler returns a scheduler running cycles instructions per frame of
+// the opcodes, and a pointer to the number of instructions executed.
+func newScheduler(cycles int, opcodes ...uint16) (*Scheduler, *int) {
+	m := &testMachine{}
+	for _, op := range opcodes {
+		m.program = append(m.program, byte(op>>8), byte(op))
+	}
+
+	s := &Scheduler{System: chip8.NewSystem(m), CyclesPerFrame: cycles}
+	executed := new(int)
+	s.Exec = func() error {
+		*executed++
+		return s.System.Step()
+	}
+	return s, executed
+}
+
+func TestCycles(t *testing.T) {
+	speed := func(n int) func() int {
+		return func() int { return n }
+	}
+
+	tests := []struct {
+		name   string
+		s      Scheduler
+		cycles int
+	}{
+		{"default", Scheduler{}, DefaultCPUSpeed / chip8.FrameRate},
+		{"speed", Scheduler{Speed: speed(1200)}, 20},
+		{"slow speed", Scheduler{Speed: speed(30)}, DefaultCPUSpeed / chip8.FrameRate},
+		{"cycles per frame", Scheduler{Speed: speed(1200), CyclesPerFrame: 7}, 7},
+	}
+
+	for _, test := range tests {
+		if n := test.s.Cycles(); n != test.cycles {
+			t.Errorf("%s: %d cycles per frame, want %d", test.name, n, test.cycles)
+		}
+	}
+}
+
+func TestFrame(t *testing.T) {
+	// loop: jump loop
+	s, executed := newScheduler(5, 0x1200)
+	if err := s.Frame(); err != nil {
+		t.Fatal(err)
+	}
+	if *executed != 5 || s.System.Frames != 1 || s.Cycle() != 0 {
+		t.Fatalf("%d instructions in %d frames, cycle %d, want 5 in 1, cycle 0", *executed, s.System.Frames, s.Cycle())
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := s.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Cycle() != 3 || s.System.Frames != 1 {
+		t.Fatalf("cycle %d of frame %d after 3 steps, want 3 of 1", s.Cycle(), s.System.Frames)
+	}
+
+	// Frame runs the rest of the frame.
+	if err := s.Frame(); err != nil {
+		t.Fatal(err)
+	}
+	if *executed != 10 || s.System.Frames != 2 || s.Cycle() != 0 {
+		t.Errorf("%d instructions in %d frames, cycle %d, want 10 in 2, cycle 0", *executed, s.System.Frames, s.Cycle())
+	}
+
+	s.Step()
+	s.Reset()
+	if s.Cycle() != 0 {
+		t.Errorf("cycle %d after Reset", s.Cycle())
+	}
+}
+
+func TestTimerCountdown(t *testing.T) {
+	// load v0 3, loadd v0, loads v0, loop: jump loop
+	s, _ := newScheduler(10, 0x6003, 0xF015, 0xF018, 0x1206)
+	sys := s.System
+	for i := 0; i < 3; i++ {
+		s.Step()
+	}
+	if sys.DT != 3 || sys.ST != 3 {
+		t.Fatalf("dt = %d, st = %d before the end of the frame, want 3", sys.DT, sys.ST)
+	}
+
+	// The timers count down once per frame, to zero.
+	for _, want := range []byte{2, 1, 0, 0} {
+		if err := s.Frame(); err != nil {
+			t.Fatal(err)
+		}
+		if sys.DT != want || sys.ST != want {
+			t.Errorf("frame %d: dt = %d, st = %d, want %d", sys.Frames, sys.DT, sys.ST, want)
+		}
+	}
+}
+
+func TestFrameEndsEarly(t *testing.T) {
+	// loadi $000, draw v0 v0 1, load v1 1, loop: jump loop
+	s, executed := newScheduler(10, 0xA000, 0xD001, 0x6101, 0x1206)
+	sys := s.System
+	sys.Quirks = chip8.QuirkVBlank
+
+	// draw waits for the next frame, which ends the frame.
+	if err := s.Frame(); err != nil {
+		t.Fatal(err)
+	}
+	if *executed != 2 || sys.Frames != 1 || sys.VBlankWait || sys.V[1] != 0 {
+		t.Fatalf("%d instructions in %d frames, want 2 in 1", *executed, sys.Frames)
+	}
+
+	// Stepping while the program waits ends the frame first.
+	s, _ = newScheduler(10, 0xA000, 0xD001, 0x6101, 0x1206)
+	sys = s.System
+	sys.Quirks = chip8.QuirkVBlank
+	s.Step()
+	s.Step()
+	if err := s.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if sys.Frames != 1 || sys.V[1] != 1 || s.Cycle() != 1 {
+		t.Errorf("frame %d, cycle %d, v1 = %d after stepping past draw, want 1, 1, 1", sys.Frames, s.Cycle(), sys.V[1])
+	}
+
+	// A halted program runs frames without executing anything.
+	s, _ = newScheduler(10, 0x00FD)
+	s.Step()
+	for i := 0; i < 3; i++ {
+		if err := s.Frame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !s.System.Halted || s.System.Frames != 3 {
+		t.Errorf("halted %v after %d frames, want true after 3", s.System.Halted, s.System.Frames)
+	}
+}
+
+func TestTickError(t *testing.T) {
+	errStop := errors.New("stop")
+	s, executed := newScheduler(4, 0x1200)
+	s.Tick = func() error {
+		s.System.Tick()
+		return errStop
+	}
+
+	if err := s.Frame(); err != errStop {
+		t.Fatalf("got %v, want the error of Tick", err)
+	}
+	if *executed != 4 || s.System.Frames != 1 || s.Cycle() != 0 {
+		t.Errorf("%d instructions in %d frames, cycle %d, want 4 in 1, cycle 0", *executed, s.System.Frames, s.Cycle())
+	}
+}
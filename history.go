@@ -0,0 +1,101 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/rect"
+)
+
+const historyLines = 1000
+
+var (
+	historyEditor = &nucular.TextEditor{Flags: nucular.EditSelectable | nucular.EditMultiline | nucular.EditClipboard | nucular.EditReadOnly}
+	rewindEditor  = &nucular.TextEditor{Flags: nucular.EditField | nucular.EditSigEnter}
+
+	// The history is only formatted again when it has changed.
+	historyLen = -1
+	historyPC  uint16
+)
+
+func openHistoryWindow() {
+	flags := nucular.WindowTitle | nucular.WindowBorder | nucular.WindowMovable | nucular.WindowScalable | nucular.WindowNonmodal | nucular.WindowClosable
+	masterWindow.PopupOpen("History", flags, rect.Rect{300, 150, 600, 460}, true, historyWindowUpdate)
+}
+
+func stepBack() {
+	system.Lock()
+	ok := debug.StepBack()
+	system.Unlock()
+
+	if !ok {
+		logger.Println("No history left")
+	}
+	atomic.StoreInt32(&debugChanged, 1)
+}
+
+func rewind() {
+	frames, err := strconv.Atoi(strings.TrimSpace(string(rewindEditor.Buffer)))
+	if err != nil || frames < 1 {
+		logger.Printf("Invalid number of frames '%s'", string(rewindEditor.Buffer))
+		return
+	}
+
+	system.Lock()
+	n := debug.Rewind(frames)
+	system.Unlock()
+
+	logger.Printf("Rewound %d instructions", n)
+	atomic.StoreInt32(&debugChanged, 1)
+}
+
+func historyWindowUpdate(w *nucular.Window) {
+	paused := atomic.LoadInt32(&emulatorPaused) != 0
+
+	w.Row(25).Static(60, 60, 60)
+	if paused {
+		if w.ButtonText("Back") {
+			stepBack()
+		}
+		rewindEditor.Edit(w)
+		if w.ButtonText("Rewind") {
+			rewind()
+		}
+	}
+
+	w.Row(w.Bounds.H - 50).Static(w.Bounds.W - 15)
+
+	system.Lock()
+	n, pc := debug.HistoryLen(), chippy.PC
+	if paused && (n != historyLen || pc != historyPC) {
+		historyLen, historyPC = n, pc
+
+		var lines []string
+		for _, e := range debug.History(historyLines) {
+			lines = append(lines, e.String())
+		}
+		historyEditor.Buffer = []rune(strings.Join(lines, "\n"))
+	}
+	system.Unlock()
+
+	historyEditor.Edit(w)
+}
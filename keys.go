@@ -0,0 +1,133 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/label"
+
+	"golang.org/x/mobile/event/key"
+
+	"github.com/andreas-jonsson/chip8studio/emulator"
+)
+
+// bindKey is the CHIP-8 key waiting for a keyboard key in the Keys menu, or
+// -1 if none is.
+var bindKey = -1
+
+// keymapFile returns the file of the project key mapping, next to the
+// project file. Projects that have not been saved have no file; their
+// mapping is written by saveSource when they are.
+func keymapFile() (string, bool) {
+	if projectFile == "" {
+		return "", false
+	}
+	return projectBase() + ".keys", true
+}
+
+// loadKeymap loads the key mapping of the project, or the default mapping
+// if the project has none.
+func loadKeymap() {
+	keymap := emulator.DefaultKeymap
+	if fileName, ok := keymapFile(); ok {
+		if data, err := ioutil.ReadFile(fileName); err == nil {
+			if keymap, err = emulator.ParseKeymap(string(data)); err != nil {
+				logger.Printf("%s: %v", fileName, err)
+				keymap = emulator.DefaultKeymap
+			}
+		} else if !os.IsNotExist(err) {
+			logger.Println(err)
+		}
+	}
+
+	system.Lock()
+	system.Keypad.Map = keymap
+	system.Keypad.Release()
+	system.Unlock()
+}
+
+func saveKeymap() {
+	fileName, ok := keymapFile()
+	if !ok {
+		return
+	}
+
+	system.Lock()
+	keymap := system.Keypad.Map
+	system.Unlock()
+
+	if err := ioutil.WriteFile(fileName, []byte(keymap.String()), 0644); err != nil {
+		logger.Println(err)
+	}
+}
+
+// captureKey binds the first key pressed to the key waiting in the Keys
+// menu. Escape cancels.
+func captureKey(w *nucular.Window) {
+	if bindKey < 0 {
+		return
+	}
+
+	for _, ev := range w.Input().Keyboard.Keys {
+		if ev.Direction == key.DirRelease {
+			continue
+		}
+		if ev.Code != key.CodeEscape {
+			system.Lock()
+			system.Keypad.Map[bindKey] = ev.Code
+			system.Unlock()
+			saveKeymap()
+		}
+		bindKey = -1
+		return
+	}
+}
+
+func keysMenu(w *nucular.Window) {
+	if w := w.Menu(label.TA("Keys", "CC"), 280, nil); w != nil {
+		captureKey(w)
+
+		system.Lock()
+		keymap := system.Keypad.Map
+		system.Unlock()
+
+		w.Row(25).Dynamic(4)
+		for _, k := range emulator.Layout {
+			name := emulator.KeyName(keymap[k])
+			if k == bindKey {
+				name = "..."
+			}
+			if w.ButtonText(fmt.Sprintf("%X: %s", k, name)) {
+				bindKey = k
+			}
+		}
+
+		w.Row(25).Dynamic(1)
+		if w.MenuItem(label.TA("Default", "LC")) {
+			bindKey = -1
+			system.Lock()
+			system.Keypad.Map = emulator.DefaultKeymap
+			system.Unlock()
+			saveKeymap()
+		}
+	}
+}
@@ -29,7 +29,6 @@ import (
 	"strings"
 	"sync/atomic"
 	"time"
-	"unsafe"
 
 	"github.com/aarzilli/nucular"
 	"github.com/aarzilli/nucular/label"
@@ -41,8 +40,9 @@ import (
 
 	"github.com/skip2/go-qrcode"
 
-	"github.com/andreas-jonsson/chip8/chip8"
 	"github.com/andreas-jonsson/chip8studio/assembler"
+	"github.com/andreas-jonsson/chip8studio/chip8"
+	"github.com/andreas-jonsson/chip8studio/debugger"
 	"github.com/andreas-jonsson/chip8studio/emulator"
 	"github.com/andreas-jonsson/chip8studio/example"
 )
@@ -52,14 +52,27 @@ var (
 	textEditor   = &nucular.TextEditor{Flags: nucular.EditBox}
 	debugEditor  = &nucular.TextEditor{Flags: nucular.EditMultiline | nucular.EditReadOnly | nucular.EditNoCursor | nucular.EditNoHorizontalScroll}
 	logEditor    = &nucular.TextEditor{Flags: nucular.EditSelectable | nucular.EditMultiline | nucular.EditClipboard | nucular.EditReadOnly}
+	watchEditor  = &nucular.TextEditor{Flags: nucular.EditField | nucular.EditSigEnter}
 
 	logBuffer bytes.Buffer
 	logger    = log.New(&logBuffer, "", 0)
 
-	chippy *chip8.System
-	system *emulator.Machine
+	chippy    *chip8.System
+	system    *emulator.Machine
+	debug     *debugger.Debugger
+	scheduler *emulator.Scheduler
+
+	emulatorDisplay = newNucularDisplay()
+
+	// Result of the last successful assembly, and the file name it was made from.
+	assembly     *assembler.Program
+	assemblyFile string
+
+	// warningsAsErrors is set in the Build menu.
+	warningsAsErrors bool
 
 	emulatorPaused int32 = 1
+	debugChanged   int32
 	projectFile    string
 	projectName    = "PONG"
 )
@@ -83,31 +96,62 @@ func main() {
 	textEditor.Paste(example.Pong)
 
 	system = &emulator.Machine{
+		Display:    emulatorDisplay,
 		CpuSpeedHz: emulator.DefaultCPUSpeed,
 		Program:    assembleBinary([]byte(example.Pong)),
+		Tone:       tone,
 	}
 	chippy = chip8.NewSystem(system)
+	debug = debugger.New(chippy)
+	startAudio()
+
+	scheduler = &emulator.Scheduler{
+		System: chippy,
+		Exec:   debug.Step,
+		Tick:   debug.Tick,
+		Speed: func() int {
+			return int(system.CpuSpeedHz)
+		},
+	}
 
 	go func() {
+		ticker := time.NewTicker(time.Second / chip8.FrameRate)
 		for {
-			if step := atomic.LoadInt32(&emulatorPaused); step <= 0 {
-				system.Lock()
-				if err := chippy.Step(); err != nil {
-					logger.Println(err)
-					masterWindow.Changed()
-				}
-
-				if chippy.Invalid() {
-					masterWindow.Changed()
-				}
-				system.Unlock()
+			// In turbo mode frames are run back to back, without waiting
+			// for the next tick.
+			step := atomic.LoadInt32(&emulatorPaused)
+			if step != 0 || atomic.LoadInt32(&turbo) == 0 {
+				<-ticker.C
+				step = atomic.LoadInt32(&emulatorPaused)
+			}
+			if step > 0 {
+				continue
+			}
 
-				if step < 0 {
+			system.Lock()
+			var err error
+			if step < 0 {
+				err = scheduler.Step()
+			} else {
+				err = scheduler.Frame()
+			}
+			if err != nil {
+				if _, ok := err.(*debugger.Break); ok {
 					atomic.StoreInt32(&emulatorPaused, 1)
+					atomic.StoreInt32(&debugChanged, 1)
 				}
+				logger.Println(err)
+				masterWindow.Changed()
+			}
+
+			if chippy.Invalid() {
+				masterWindow.Changed()
 			}
+			system.Unlock()
 
-			time.Sleep(time.Second / system.CpuSpeedHz)
+			if step < 0 {
+				atomic.StoreInt32(&emulatorPaused, 1)
+			}
 		}
 	}()
 
@@ -121,8 +165,11 @@ func runAssembler() []byte {
 
 		system.Lock()
 		system.Program = prog
-		system.Unlock()
 		chippy.Reset()
+		scheduler.Reset()
+		debug.ClearHistory()
+		updateBreakpoints()
+		system.Unlock()
 		return prog
 	}
 	return nil
@@ -131,40 +178,78 @@ func runAssembler() []byte {
 func assembleBinary(source []byte) []byte {
 	fp, err := ioutil.TempFile("", "")
 	if err != nil {
-		log.Fatalln(err)
+		logger.Println(err)
+		return nil
 	}
+	defer os.Remove(fp.Name())
 
-	_, errs := assembler.Assemble(projectName, bytes.NewReader(source), fp)
-	if len(errs) > 0 {
-		return nil
+	// Includes are resolved relative to the project file, once it has been saved.
+	fileName := projectName
+	if projectFile != "" {
+		fileName = projectFile
 	}
 
-	fpName := fp.Name()
+	result, diags := assembler.Assemble(fileName, bytes.NewReader(source), fp, assembler.Options{WarningsAsErrors: warningsAsErrors})
 	fp.Close()
 
-	prog, err := ioutil.ReadFile(fpName)
+	if assembler.HasErrors(diags) {
+		logger.Printf("assembly failed with %d error(s)", assembler.CountErrors(diags))
+		return nil
+	}
+
+	prog, err := ioutil.ReadFile(fp.Name())
 	if err != nil {
-		log.Fatalln(err)
+		logger.Println(err)
+		return nil
 	}
-	os.Remove(fpName)
 
+	assembly, assemblyFile = result, fileName
 	return prog
 }
 
+func saveListing(filename string, prog []byte) {
+	fp, err := os.Create(filename)
+	if err != nil {
+		logger.Println(err)
+		return
+	}
+	defer fp.Close()
+
+	if err := assembly.WriteListing(fp, prog); err != nil {
+		logger.Println(err)
+	}
+}
+
 func saveSource() {
 	source := []byte(string(textEditor.Buffer))
 	if err := ioutil.WriteFile(projectFile, source, 644); err != nil {
 		logger.Println(err)
 		logEditor.Buffer = []rune(string(logBuffer.Bytes()))
 		masterWindow.Changed()
+		return
+	}
+
+	system.Lock()
+	customKeys := system.Keypad.Map != emulator.DefaultKeymap
+	customQuirks := chippy.Quirks != 0
+	system.Unlock()
+	if customKeys {
+		saveKeymap()
+	}
+	if customQuirks {
+		saveQuirks()
 	}
 }
 
+func setProjectFile(filename string) {
+	projectFile = filename
+	projectBase := filepath.Base(projectFile)
+	projectName = strings.ToUpper(strings.TrimRight(projectBase, filepath.Ext(projectBase)))
+}
+
 func saveAsDialog() {
 	if filename, err := dialog.File().Filter("Chip8 Assembly Source", "asm").Title("Save As").Save(); err == nil {
-		projectFile = filename
-		projectBase := filepath.Base(projectFile)
-		projectName = strings.ToUpper(strings.TrimRight(projectBase, filepath.Ext(projectBase)))
+		setProjectFile(filename)
 		saveSource()
 	}
 }
@@ -179,12 +264,19 @@ func textWindowUpdate(w *nucular.Window) {
 			projectName = "UNTITLED"
 			projectFile = ""
 			textEditor.Buffer = nil
+			loadKeymap()
+			loadQuirks()
+			breakpointLines = make(map[int]bool)
 			runAssembler()
 		}
 		if w.MenuItem(label.TA("Open", "LC")) {
 			if filename, err := dialog.File().Filter("Chip8 Assembly Source", "asm").Load(); err == nil {
 				if source, err := ioutil.ReadFile(filename); err == nil {
+					setProjectFile(filename)
+					loadKeymap()
+					loadQuirks()
 					textEditor.Buffer = []rune(strings.Replace(string(source), "\r\n", "\n", -1))
+					breakpointLines = make(map[int]bool)
 					runAssembler()
 					masterWindow.Changed()
 				}
@@ -206,11 +298,12 @@ func textWindowUpdate(w *nucular.Window) {
 			}
 		}
 	}
-	if w := w.Menu(label.TA("Build", "CC"), 120, nil); w != nil {
+	if w := w.Menu(label.TA("Build", "CC"), 160, nil); w != nil {
 		w.Row(25).Dynamic(1)
 		if w.MenuItem(label.TA("Assemble", "LC")) {
 			runAssembler()
 		}
+		w.CheckboxText("Warnings as errors", &warningsAsErrors)
 		if w.MenuItem(label.TA("Bundle (Binary)", "LC")) {
 			if prog := runAssembler(); prog != nil {
 				if filename, err := dialog.File().Filter("Chip8 Binary", "ch8").Title("Save As").Save(); err == nil {
@@ -218,6 +311,13 @@ func textWindowUpdate(w *nucular.Window) {
 				}
 			}
 		}
+		if w.MenuItem(label.TA("Listing", "LC")) {
+			if prog := runAssembler(); prog != nil {
+				if filename, err := dialog.File().Filter("Listing", "lst").Title("Write Listing").Save(); err == nil {
+					saveListing(filename, prog)
+				}
+			}
+		}
 		if w.MenuItem(label.TA("Bundle (QR-Code)", "LC")) {
 			if prog := runAssembler(); prog != nil {
 				const imageSize = 512
@@ -246,10 +346,23 @@ func textWindowUpdate(w *nucular.Window) {
 			}
 		}
 	}
+	if w := w.Menu(label.TA("View", "CC"), 120, nil); w != nil {
+		w.Row(25).Dynamic(1)
+		if w.MenuItem(label.TA("Memory", "LC")) {
+			openMemoryWindow()
+		}
+		if w.MenuItem(label.TA("History", "LC")) {
+			openHistoryWindow()
+		}
+	}
 	w.MenubarEnd()
 
-	w.Row(w.Bounds.H - 50).Static(w.Bounds.W - 15)
-	textEditor.Edit(w)
+	if keyPressed(w, key.CodeF9) {
+		toggleBreakpoint()
+	}
+	highlightPC()
+
+	editorUpdate(w, w.Bounds.H-50)
 }
 
 func logWindowUpdate(w *nucular.Window) {
@@ -259,15 +372,17 @@ func logWindowUpdate(w *nucular.Window) {
 }
 
 func debugWindowUpdate(w *nucular.Window) {
-	w.Row(25).Static(60, 60, 60)
+	w.Row(25).Static(60, 60, 60, 60, 60, 60, 80)
 
 	updateDebugWindow := false
 	if atomic.LoadInt32(&emulatorPaused) != 0 {
 		if w.ButtonText("Run") {
+			resume()
 			atomic.StoreInt32(&emulatorPaused, 0)
 			logger.Println("Run")
 		}
 		if w.ButtonText("Step") {
+			resume()
 			atomic.StoreInt32(&emulatorPaused, -1)
 			logger.Println("Step")
 			updateDebugWindow = true
@@ -276,6 +391,15 @@ func debugWindowUpdate(w *nucular.Window) {
 				time.Sleep(time.Millisecond)
 			}
 		}
+		if w.ButtonText("Over") {
+			stepOver()
+		}
+		if w.ButtonText("Out") {
+			stepOut()
+		}
+		if w.ButtonText("Cursor") {
+			runToCursor()
+		}
 	} else {
 		if w.ButtonText("Pause") {
 			atomic.StoreInt32(&emulatorPaused, 1)
@@ -286,36 +410,77 @@ func debugWindowUpdate(w *nucular.Window) {
 	if w.ButtonText("Reset") {
 		system.Lock()
 		chippy.Reset()
+		scheduler.Reset()
+		debug.ClearHistory()
 		system.Unlock()
 		atomic.StoreInt32(&emulatorPaused, 1)
 		logger.Println("Reset")
 		updateDebugWindow = true
 	}
 
-	w.Row(w.Bounds.H - 50).Static(w.Bounds.W - 15)
+	if w.ButtonText("Breakpoint") {
+		toggleBreakpoint()
+		updateDebugWindow = true
+	}
+
+	w.Row(25).Static(w.Bounds.W-155, 65, 65)
+	if watchEditor.Edit(w)&nucular.EditCommitted != 0 {
+		addWatchpoint()
+		updateDebugWindow = true
+	}
+	if w.ButtonText("Watch") {
+		addWatchpoint()
+		updateDebugWindow = true
+	}
+	if w.ButtonText("Clear") {
+		system.Lock()
+		debug.ClearWatchpoints()
+		system.Unlock()
+		logger.Println("Watchpoints cleared")
+		updateDebugWindow = true
+	}
+
+	w.Row(w.Bounds.H-80).Static(300, w.Bounds.W-320)
+
+	if atomic.SwapInt32(&debugChanged, 0) != 0 {
+		updateDebugWindow = true
+	}
 
 	if debugEditor.Buffer == nil || updateDebugWindow || atomic.LoadInt32(&emulatorPaused) == 0 {
 		system.Lock()
 		var buf bytes.Buffer
 		chippy.Dump(&buf, projectName)
+		dumpBreakpoints(&buf)
+		dumpWatchpoints(&buf)
 		system.Unlock()
 
 		debugEditor.Buffer = []rune(buf.String())
 	}
 	debugEditor.Edit(w)
+
+	if sw := w.GroupBegin("Disassembly", nucular.WindowBorder); sw != nil {
+		disassemblyUpdate(sw)
+		sw.GroupEnd()
+	}
 }
 
 func emulatorWindowUpdate(w *nucular.Window) {
-	if system.Window == nil {
-		system.Window = w
-	}
+	emulatorDisplay.Window = w
+
+	w.MenubarBegin()
+	w.Row(20).Static(50, 50, 50, 50, 60)
+	stateMenu(w)
+	speedMenu(w)
+	soundMenu(w)
+	keysMenu(w)
+	quirksMenu(w)
+	w.MenubarEnd()
+
+	captureKey(w)
 
 	system.Lock()
-	if keys := w.Input().Keyboard.Keys; len(keys) > 0 {
-		k := keys[0]
-		system.Event = (*key.Event)(unsafe.Pointer(&k)) // This cast is not very nice. :(
-	} else {
-		system.Event = nil
+	for _, ev := range w.Input().Keyboard.Keys {
+		system.Keypad.Event(ev)
 	}
 
 	chippy.Invalidate()
@@ -0,0 +1,274 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/rect"
+
+	"github.com/andreas-jonsson/chip8studio/chip8"
+)
+
+const (
+	memoryColumns = 16
+	memoryRows    = 16
+	memoryPage    = memoryColumns * memoryRows
+
+	spriteScale = 8
+)
+
+var (
+	memoryAddr = chip8.ProgramStart
+
+	gotoEditor   = &nucular.TextEditor{Flags: nucular.EditField | nucular.EditSigEnter}
+	setEditor    = &nucular.TextEditor{Flags: nucular.EditField | nucular.EditSigEnter}
+	spriteEditor = &nucular.TextEditor{Flags: nucular.EditField | nucular.EditSigEnter}
+
+	pcColor     = color.RGBA{0xFF, 0x60, 0x60, 0xFF}
+	indexColor  = color.RGBA{0x60, 0xB0, 0xFF, 0xFF}
+	spriteColor = color.RGBA{0x60, 0xE0, 0x60, 0xFF}
+	byteColor   = color.RGBA{0xC0, 0xC0, 0xC0, 0xFF}
+	lableColor  = color.RGBA{0xFF, 0xD0, 0x60, 0xFF}
+)
+
+func openMemoryWindow() {
+	flags := nucular.WindowTitle | nucular.WindowBorder | nucular.WindowMovable | nucular.WindowScalable | nucular.WindowNonmodal | nucular.WindowClosable
+	masterWindow.PopupOpen("Memory", flags, rect.Rect{200, 100, 760, 560}, true, memoryWindowUpdate)
+}
+
+func showMemory(addr int) {
+	addr -= addr % memoryColumns
+	if addr > chip8.MemorySize-memoryPage {
+		addr = chip8.MemorySize - memoryPage
+	}
+	if addr < 0 {
+		addr = 0
+	}
+	memoryAddr = addr
+}
+
+// lablesAt returns the lables of the last assembly, by address.
+func lablesAt() map[int][]string {
+	lables := make(map[int][]string)
+	if assembly != nil {
+		for name, addr := range assembly.Lables {
+			lables[int(addr)] = append(lables[int(addr)], name)
+		}
+	}
+	for _, names := range lables {
+		sort.Strings(names)
+	}
+	return lables
+}
+
+// parseAddress parses an address as a number or a lable.
+func parseAddress(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if assembly != nil {
+		if addr, ok := assembly.Lables[s]; ok {
+			return int(addr), nil
+		}
+	}
+
+	var (
+		n   uint64
+		err error
+	)
+	if strings.HasPrefix(s, "$") {
+		n, err = strconv.ParseUint(s[1:], 16, 16)
+	} else {
+		n, err = strconv.ParseUint(s, 0, 16)
+	}
+	if err != nil || n >= chip8.MemorySize {
+		return 0, fmt.Errorf("invalid address '%s'", s)
+	}
+	return int(n), nil
+}
+
+// spriteImage renders rows rows of memory from addr as a sprite of width 8
+// or 16 pixels, with two bytes per row if it is 16.
+func spriteImage(memory []byte, addr, width, rows int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width*spriteScale, rows*spriteScale))
+	for y := 0; y < rows*spriteScale; y++ {
+		row := addr + y/spriteScale*width/8
+		for x := 0; x < width*spriteScale; x++ {
+			col := x / spriteScale
+			bits := memory[(row+col/8)%len(memory)]
+
+			c := color.RGBA{0x20, 0x20, 0x20, 0xFF}
+			if bits&(0x80>>uint(col%8)) != 0 {
+				c = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// spriteRegion returns the region typed in the sprite field, as an address,
+// a width and a number of rows, or the last sprite drawn if it is empty.
+// Typed regions are 8 pixels wide.
+func spriteRegion(sys *chip8.System) (int, int, int, error) {
+	fields := strings.Fields(string(spriteEditor.Buffer))
+	if len(fields) == 0 {
+		if sys.SpriteWidth == 0 {
+			return 0, 0, 0, nil
+		}
+		return int(sys.SpriteAddr), sys.SpriteWidth, sys.SpriteSize * 8 / sys.SpriteWidth, nil
+	}
+
+	addr, err := parseAddress(fields[0])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	rows := 8
+	if len(fields) > 1 {
+		if rows, err = strconv.Atoi(fields[1]); err != nil || rows < 1 || rows > 32 {
+			return 0, 0, 0, fmt.Errorf("invalid sprite height '%s'", fields[1])
+		}
+	}
+	return addr, 8, rows, nil
+}
+
+func memoryWindowUpdate(w *nucular.Window) {
+	system.Lock()
+	state := chippy.State
+	spriteAddr, spriteSize := int(chippy.SpriteAddr), chippy.SpriteSize
+	previewAddr, previewWidth, previewRows, previewErr := spriteRegion(chippy)
+	system.Unlock()
+
+	paused := atomic.LoadInt32(&emulatorPaused) != 0
+	pc, index := int(state.PC), int(state.I)
+
+	w.Row(25).Static(35, 35, 35, 35, 45, 45, 45, 120, 50)
+	if w.ButtonText("<<") {
+		showMemory(memoryAddr - 4*memoryPage)
+	}
+	if w.ButtonText("<") {
+		showMemory(memoryAddr - memoryPage)
+	}
+	if w.ButtonText(">") {
+		showMemory(memoryAddr + memoryPage)
+	}
+	if w.ButtonText(">>") {
+		showMemory(memoryAddr + 4*memoryPage)
+	}
+	if w.ButtonText("PC") {
+		showMemory(pc)
+	}
+	if w.ButtonText("I") {
+		showMemory(index)
+	}
+	if w.ButtonText("Spr") {
+		showMemory(spriteAddr)
+	}
+	goTo := gotoEditor.Edit(w)&nucular.EditCommitted != 0
+	if w.ButtonText("Goto") || goTo {
+		if addr, err := parseAddress(string(gotoEditor.Buffer)); err == nil {
+			showMemory(addr)
+		} else {
+			logger.Println(err)
+		}
+	}
+
+	w.Row(18).Static(200, 200, 200)
+	w.LabelColored(fmt.Sprintf("PC $%03X", pc), "LC", pcColor)
+	w.LabelColored(fmt.Sprintf("I $%03X", index), "LC", indexColor)
+	w.LabelColored(fmt.Sprintf("Sprite $%03X, %d bytes", spriteAddr, spriteSize), "LC", spriteColor)
+
+	widths := []int{40}
+	for i := 0; i < memoryColumns; i++ {
+		widths = append(widths, 20)
+	}
+	widths = append(widths, 130, 200)
+
+	lables := lablesAt()
+	for row := 0; row < memoryRows; row++ {
+		addr := memoryAddr + row*memoryColumns
+		w.Row(18).Static(widths...)
+		w.Label(fmt.Sprintf("%04X", addr), "LC")
+
+		var ascii []byte
+		var names []string
+		for i := addr; i < addr+memoryColumns; i++ {
+			b := state.Memory[i]
+
+			c := byteColor
+			switch {
+			case i == pc || i == pc+1:
+				c = pcColor
+			case i == index:
+				c = indexColor
+			case i >= spriteAddr && i < spriteAddr+spriteSize:
+				c = spriteColor
+			}
+			w.LabelColored(fmt.Sprintf("%02X", b), "LC", c)
+
+			if b < 0x20 || b > 0x7E {
+				b = '.'
+			}
+			ascii = append(ascii, b)
+			names = append(names, lables[i]...)
+		}
+
+		w.Label(string(ascii), "LC")
+		w.LabelColored(strings.Join(names, " "), "LC", lableColor)
+	}
+
+	if paused {
+		w.Row(25).Static(200, 50)
+		set := setEditor.Edit(w)&nucular.EditCommitted != 0
+		if w.ButtonText("Set") || set {
+			system.Lock()
+			err := debug.Set(string(setEditor.Buffer))
+			system.Unlock()
+
+			if err != nil {
+				logger.Println(err)
+			} else {
+				logger.Printf("Set %s", string(setEditor.Buffer))
+				setEditor.Buffer = nil
+				atomic.StoreInt32(&debugChanged, 1)
+			}
+		}
+	}
+
+	w.Row(25).Static(60, 140)
+	w.Label("Sprite", "LC")
+	spriteEditor.Edit(w)
+
+	if previewErr != nil {
+		w.Row(18).Dynamic(1)
+		w.Label(previewErr.Error(), "LC")
+		return
+	}
+
+	if previewRows > 0 {
+		w.Row(previewRows * spriteScale).Static(previewWidth * spriteScale)
+		w.Image(spriteImage(state.Memory[:], previewAddr, previewWidth, previewRows))
+	}
+}
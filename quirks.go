@@ -0,0 +1,102 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/label"
+
+	"github.com/andreas-jonsson/chip8studio/chip8"
+)
+
+// quirksFile returns the file of the project quirks, next to the project
+// file. Projects that have not been saved have no file; their quirks are
+// written by saveSource when they are.
+func quirksFile() (string, bool) {
+	if projectFile == "" {
+		return "", false
+	}
+	return projectBase() + ".quirks", true
+}
+
+// loadQuirks loads the quirks of the project, or no quirks if the project
+// has none.
+func loadQuirks() {
+	var quirks chip8.Quirks
+	if fileName, ok := quirksFile(); ok {
+		var err error
+		if quirks, err = chip8.LoadQuirks(fileName); err != nil {
+			logger.Println(err)
+		}
+	}
+
+	system.Lock()
+	chippy.Quirks = quirks
+	system.Unlock()
+}
+
+func saveQuirks() {
+	fileName, ok := quirksFile()
+	if !ok {
+		return
+	}
+
+	system.Lock()
+	quirks := chippy.Quirks
+	system.Unlock()
+
+	if err := ioutil.WriteFile(fileName, []byte(quirks.String()+"\n"), 0644); err != nil {
+		logger.Println(err)
+	}
+}
+
+func setQuirks(quirks chip8.Quirks) {
+	system.Lock()
+	chippy.Quirks = quirks
+	system.Unlock()
+
+	saveQuirks()
+	logger.Printf("Quirks: %v", quirks)
+	atomic.StoreInt32(&debugChanged, 1)
+}
+
+func quirksMenu(w *nucular.Window) {
+	if w := w.Menu(label.TA("Quirks", "CC"), 180, nil); w != nil {
+		system.Lock()
+		quirks := chippy.Quirks
+		system.Unlock()
+
+		w.Row(25).Dynamic(1)
+		for _, p := range chip8.Profiles {
+			selected := p.Quirks == quirks
+			if w.CheckboxText(p.Name, &selected) {
+				setQuirks(p.Quirks)
+			}
+		}
+
+		for _, q := range chip8.AllQuirks {
+			on := quirks.Has(q)
+			if w.CheckboxText(q.Name(), &on) {
+				setQuirks(quirks ^ q)
+			}
+		}
+	}
+}
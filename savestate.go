@@ -0,0 +1,143 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/label"
+
+	"github.com/andreas-jonsson/chip8studio/chip8"
+)
+
+const stateSlots = 4
+
+// projectBase returns the project file without extension, for files kept
+// next to it. It must only be called for saved projects.
+func projectBase() string {
+	return strings.TrimSuffix(projectFile, filepath.Ext(projectFile))
+}
+
+// stateFile returns the file of a save state slot, next to the project file.
+// Projects that have not been saved have no slots.
+func stateFile(slot int) (string, bool) {
+	if projectFile == "" {
+		return "", false
+	}
+	return fmt.Sprintf("%s.%d.c8s", projectBase(), slot), true
+}
+
+func saveState(slot int) {
+	fileName, ok := stateFile(slot)
+	if !ok {
+		logger.Println("Save the project to get save state slots")
+		return
+	}
+
+	if err := writeState(fileName); err != nil {
+		logger.Println(err)
+		return
+	}
+	logger.Printf("State saved to slot %d", slot)
+}
+
+// writeState saves the state to a temporary file next to fileName, and
+// renames it over fileName once it is complete, so a failed save leaves
+// the slot as it was.
+func writeState(fileName string) error {
+	fp, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fp.Name())
+
+	system.Lock()
+	err = chippy.SaveState(fp, system.Program)
+	system.Unlock()
+
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(fp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(fp.Name(), fileName)
+}
+
+func loadState(slot int) {
+	fileName, ok := stateFile(slot)
+	if !ok {
+		logger.Println("Save the project to get save state slots")
+		return
+	}
+
+	fp, err := os.Open(fileName)
+	if err != nil {
+		logger.Println(err)
+		return
+	}
+	defer fp.Close()
+
+	system.Lock()
+	quirks := chippy.Quirks
+	err = chippy.LoadState(fp, system.Program)
+	if err == nil {
+		debug.ClearHistory()
+		scheduler.Reset()
+	}
+	system.Unlock()
+
+	switch err {
+	case nil:
+		logger.Printf("State loaded from slot %d", slot)
+		if chippy.Quirks != quirks {
+			logger.Printf("Quirks: %v, as the state was saved with", chippy.Quirks)
+		}
+		atomic.StoreInt32(&debugChanged, 1)
+	case chip8.ErrProgramMismatch:
+		logger.Printf("Slot %d was saved with a different program, assemble the program it was saved with to load it", slot)
+	default:
+		logger.Printf("Slot %d: %v", slot, err)
+	}
+}
+
+func stateMenu(w *nucular.Window) {
+	if w := w.Menu(label.TA("State", "CC"), 120, nil); w != nil {
+		w.Row(25).Dynamic(1)
+		for slot := 1; slot <= stateSlots; slot++ {
+			if w.MenuItem(label.TA(fmt.Sprintf("Save slot %d", slot), "LC")) {
+				saveState(slot)
+			}
+		}
+		for slot := 1; slot <= stateSlots; slot++ {
+			if w.MenuItem(label.TA(fmt.Sprintf("Load slot %d", slot), "LC")) {
+				loadState(slot)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/label"
+
+	"github.com/andreas-jonsson/chip8studio/audio"
+	"github.com/andreas-jonsson/chip8studio/audio/device"
+)
+
+var (
+	tone   = audio.NewTone()
+	player *audio.Player
+
+	// Settings in the Sound menu. Volume is in percent and pitch in Hz.
+	soundVolume = int(audio.DefaultVolume * 100)
+	soundPitch  = audio.DefaultPitch
+)
+
+// startAudio plays the tone on the audio device, or where that is not
+// supported, through the first audio player found. Without either the
+// emulator runs silent.
+func startAudio() {
+	sink, err := device.Open(tone.SampleRate)
+	if err != nil {
+		if sink, err = audio.NewCommand(tone.SampleRate); err != nil {
+			logger.Printf("Sound is disabled: %v", err)
+			return
+		}
+	}
+	player = audio.NewPlayer(tone, sink)
+}
+
+func soundMenu(w *nucular.Window) {
+	if w := w.Menu(label.TA("Sound", "CC"), 200, nil); w != nil {
+		w.Row(25).Dynamic(1)
+		volume, pitch := soundVolume, soundPitch
+		w.PropertyInt("Volume:", 0, &soundVolume, 100, 5, 1)
+		w.PropertyInt("Pitch:", 50, &soundPitch, 2000, 10, 5)
+
+		if volume != soundVolume || pitch != soundPitch {
+			tone.Lock()
+			tone.Volume = float64(soundVolume) / 100
+			tone.Pitch = float64(soundPitch)
+			tone.Unlock()
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright (C) 2018 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/aarzilli/nucular"
+	"github.com/aarzilli/nucular/label"
+
+	"github.com/andreas-jonsson/chip8studio/chip8"
+)
+
+var (
+	// turbo is 1 while frames are run as fast as possible.
+	turbo int32
+
+	// Instructions per frame in the Speed menu, 0 to follow the speed of
+	// the machine.
+	cyclesPerFrame int
+)
+
+func speedMenu(w *nucular.Window) {
+	if w := w.Menu(label.TA("Speed", "CC"), 220, nil); w != nil {
+		system.Lock()
+		speed := int(system.CpuSpeedHz)
+		cycles := scheduler.Cycles()
+		system.Unlock()
+
+		w.Row(25).Dynamic(1)
+		if w.PropertyInt("Cycles per frame:", 0, &cyclesPerFrame, 1000, 1, 1) {
+			system.Lock()
+			scheduler.CyclesPerFrame = cyclesPerFrame
+			system.Unlock()
+		}
+
+		if cyclesPerFrame == 0 {
+			w.Label(fmt.Sprintf("%d per frame at %d Hz", cycles, speed), "LC")
+		} else {
+			w.Label(fmt.Sprintf("%d Hz", cycles*chip8.FrameRate), "LC")
+		}
+
+		on := atomic.LoadInt32(&turbo) != 0
+		if w.CheckboxText("Turbo", &on) {
+			if on {
+				atomic.StoreInt32(&turbo, 1)
+			} else {
+				atomic.StoreInt32(&turbo, 0)
+			}
+		}
+	}
+}